@@ -0,0 +1,88 @@
+package jokesontap
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Admin serves endpoints for operators, like /debug/status, on a port of its own which should not be exposed
+// publicly.
+type Admin struct {
+	// Port is the port where the admin server will listen.
+	Port int
+	// Token, when set, must be given as a bearer token in the Authorization header of every admin request.
+	Token string
+
+	mux *http.ServeMux
+	// mu guards httpSrv and shutdown.
+	mu       sync.Mutex
+	httpSrv  *http.Server
+	shutdown bool
+}
+
+// NewAdmin creates an Admin which will listen on port, with no endpoints.
+func NewAdmin(port int) *Admin {
+	return &Admin{Port: port, mux: http.NewServeMux()}
+}
+
+// Handle serves h at path to authenticated requests.
+func (a *Admin) Handle(path string, h http.Handler) {
+	a.mux.Handle(path, withRequestID(a.authenticate(h)))
+}
+
+// authenticate serves h to requests with the admin token, responding 401 Unauthorized to any other request.
+func (a *Admin) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if a.Token != "" {
+			// ref: https://tools.ietf.org/html/rfc6750#section-2.1
+			given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(a.Token)) != 1 {
+				log.WithContext(req.Context()).WithField("path", req.URL.Path).Warn("unauthorized admin request")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="jokesontap admin"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// ListenAndServe listens on the admin port and serves the admin endpoints until Shutdown is called, after which
+// http.ErrServerClosed is returned.
+func (a *Admin) ListenAndServe() error {
+	httpSrv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", a.Port),
+		Handler:      a.mux,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  30 * time.Second,
+	}
+
+	a.mu.Lock()
+	if a.shutdown {
+		a.mu.Unlock()
+		return http.ErrServerClosed
+	}
+	a.httpSrv = httpSrv
+	a.mu.Unlock()
+	return httpSrv.ListenAndServe()
+}
+
+// Shutdown gracefully stops the admin server, waiting for in-flight requests to complete until ctx is done.
+func (a *Admin) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.shutdown = true
+	httpSrv := a.httpSrv
+	a.mu.Unlock()
+
+	if httpSrv == nil {
+		return nil
+	}
+	return httpSrv.Shutdown(ctx)
+}
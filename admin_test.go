@@ -0,0 +1,45 @@
+package jokesontap
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminRequiresToken(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		expStatus     int
+	}{
+		{"no_token_configured", "", "", http.StatusOK},
+		{"valid", "s3cret", "Bearer s3cret", http.StatusOK},
+		{"missing", "s3cret", "", http.StatusUnauthorized},
+		{"wrong", "s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"not_bearer", "s3cret", "Basic s3cret", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+			a := NewAdmin(0)
+			a.Token = tt.token
+			a.Handle("/debug/status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest("GET", "/debug/status", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			a.mux.ServeHTTP(w, req)
+			assert.Equal(tt.expStatus, w.Code)
+			assert.NotEmpty(w.Header().Get(requestIDHeader))
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package jokesontap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/swtch1/jokesontap/breaker"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error codes identify the errors served by the server.  Codes are stable, so clients can rely on them where the
+// message may change.
+const (
+	CodeInvalidName         = "invalid_name"
+	CodeInvalidCategory     = "invalid_category"
+	CodeInvalidCount        = "invalid_count"
+	CodeJokeNotFound        = "joke_not_found"
+	CodeNoJokesInCategory   = "no_jokes_in_category"
+	CodeRateLimited         = "rate_limited"
+	CodeNamesExhausted      = "names_exhausted"
+	CodeNoCachedName        = "no_cached_name"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeUpstreamError       = "upstream_error"
+	CodeUpstreamBadPayload  = "upstream_bad_payload"
+	CodeUpstreamTimeout     = "upstream_timeout"
+	CodeRequestCanceled     = "request_canceled"
+	CodeInternal            = "internal_error"
+)
+
+// statusClientClosedRequest is served, as nginx does, when the client went away before it could be answered.  Nobody
+// reads the response, but the status keeps abandoned requests out of the server errors in logs and metrics.
+const statusClientClosedRequest = 499
+
+// ApiError is an error served to a client.  Message is safe to show to clients, while the underlying error, which
+// may describe the server's internals, is only logged.
+type ApiError struct {
+	// Status is the HTTP status code the error is served with.
+	Status int
+	// Code is the stable error code.
+	Code    string
+	Message string
+	// RetryAfter, when positive, is how long the client should wait before trying again.
+	RetryAfter time.Duration
+	// Err is the error which caused the ApiError.
+	Err error
+}
+
+func (e *ApiError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Message, e.Err)
+}
+
+// Cause gets the error which caused the ApiError.
+func (e *ApiError) Cause() error {
+	return e.Err
+}
+
+// toApiError describes err as it should be served to a client.  Errors from invalid requests are served with their
+// own message, any other error with a message which doesn't give away the server's internals.
+func toApiError(err error) *ApiError {
+	if apiErr, ok := err.(*ApiError); ok {
+		return apiErr
+	}
+	if retry, open := breaker.RetryAfter(err); open {
+		if retry < time.Second {
+			retry = time.Second
+		}
+		return &ApiError{
+			Status:     http.StatusServiceUnavailable,
+			Code:       CodeUpstreamUnavailable,
+			Message:    "an upstream service is unavailable, please try again later",
+			RetryAfter: retry,
+			Err:        err,
+		}
+	}
+
+	if canceled(err) {
+		return &ApiError{Status: statusClientClosedRequest, Code: CodeRequestCanceled, Message: "the request was cancelled", Err: err}
+	}
+
+	cause := errors.Cause(err)
+	switch cause {
+	case ErrInvalidName:
+		return &ApiError{Status: http.StatusBadRequest, Code: CodeInvalidName, Message: err.Error(), Err: err}
+	case ErrInvalidCategory:
+		return &ApiError{Status: http.StatusBadRequest, Code: CodeInvalidCategory, Message: err.Error(), Err: err}
+	case ErrInvalidJokeCount:
+		return &ApiError{Status: http.StatusBadRequest, Code: CodeInvalidCount, Message: err.Error(), Err: err}
+	case ErrJokeNotFound:
+		return &ApiError{Status: http.StatusNotFound, Code: CodeJokeNotFound, Message: err.Error(), Err: err}
+	case ErrNoJokesInCategory:
+		return &ApiError{Status: http.StatusNotFound, Code: CodeNoJokesInCategory, Message: err.Error(), Err: err}
+	case ErrNoPinnedName:
+		// ref: https://tools.ietf.org/html/rfc7234#section-5.2.1.7
+		return &ApiError{Status: http.StatusGatewayTimeout, Code: CodeNoCachedName, Message: err.Error(), Err: err}
+	case ErrNoNamesAvailable:
+		return &ApiError{Status: http.StatusServiceUnavailable, Code: CodeNamesExhausted, Message: "no names are available right now, please try again later", Err: err}
+	case ErrNamesApiTooManyRequests, ErrJokesApiTooManyRequests:
+		retry, _ := upstreamRetryAfter(err)
+		return &ApiError{
+			Status:     http.StatusTooManyRequests,
+			Code:       CodeRateLimited,
+			Message:    "an upstream service is rate limiting the server, please try again later",
+			RetryAfter: retry,
+			Err:        err,
+		}
+	case ErrNon200NameApiResponse, ErrNon200JokeApiResponse:
+		return &ApiError{Status: http.StatusBadGateway, Code: CodeUpstreamError, Message: "an upstream service failed", Err: err}
+	case ErrUnsuccessfulJokeQuery, ErrNameNotSubstituted:
+		return &ApiError{Status: http.StatusBadGateway, Code: CodeUpstreamBadPayload, Message: "an upstream service responded unexpectedly", Err: err}
+	case context.DeadlineExceeded:
+		return &ApiError{Status: http.StatusGatewayTimeout, Code: CodeUpstreamTimeout, Message: "an upstream service took too long to respond", Err: err}
+	}
+
+	switch c := cause.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return &ApiError{Status: http.StatusBadGateway, Code: CodeUpstreamBadPayload, Message: "an upstream service responded unexpectedly", Err: err}
+	case net.Error:
+		if c.Timeout() {
+			return &ApiError{Status: http.StatusGatewayTimeout, Code: CodeUpstreamTimeout, Message: "an upstream service took too long to respond", Err: err}
+		}
+		return &ApiError{Status: http.StatusBadGateway, Code: CodeUpstreamError, Message: "an upstream service could not be reached", Err: err}
+	}
+	return &ApiError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "internal server error", Err: err}
+}
+
+// ErrorResponse is the JSON representation of an error served by the server.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes an error served by the server.
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError logs err and writes it in the given content type with the status code it maps to.  Plain text errors
+// are written as the error code followed by the message.
+func writeError(w http.ResponseWriter, req *http.Request, contentType string, err error) {
+	apiErr := toApiError(err)
+	entry := log.WithContext(req.Context()).WithError(err).WithFields(log.Fields{"code": apiErr.Code, "status": apiErr.Status})
+	switch {
+	case apiErr.Code == CodeUpstreamUnavailable:
+		// every request fails this way while a breaker is open, and the breaker has already logged why
+		entry.Debug("request failed")
+	case apiErr.Code == CodeRequestCanceled:
+		entry.Debug("request cancelled by client")
+	case apiErr.Status >= http.StatusInternalServerError:
+		entry.Error("request failed")
+	default:
+		entry.Debug("request failed")
+	}
+
+	if apiErr.RetryAfter > 0 {
+		// ref: https://tools.ietf.org/html/rfc7231#section-7.1.3
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(apiErr.RetryAfter.Seconds()))))
+	}
+	w.Header().Add("Vary", "Accept")
+	switch contentType {
+	case contentTypeJson:
+		w.Header().Set("Content-Type", contentTypeJson)
+		w.WriteHeader(apiErr.Status)
+		resp := ErrorResponse{Error: ErrorBody{Code: apiErr.Code, Message: apiErr.Message}}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			entry.WithError(err).Error("unable to write error response")
+		}
+	default:
+		w.Header().Set("Content-Type", contentTypeText+"; charset=utf-8")
+		w.WriteHeader(apiErr.Status)
+		fmt.Fprintf(w, "%s: %s\n", apiErr.Code, apiErr.Message)
+	}
+}
@@ -0,0 +1,169 @@
+package jokesontap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/swtch1/jokesontap/breaker"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestErrorsMapToStatusCodes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		err       error
+		expStatus int
+		expCode   string
+	}{
+		{"invalid_name", errors.Wrap(ErrInvalidName, "first name"), http.StatusBadRequest, CodeInvalidName},
+		{"invalid_category", errors.Wrap(ErrInvalidCategory, "'<b>'"), http.StatusBadRequest, CodeInvalidCategory},
+		{"invalid_count", errors.Wrap(ErrInvalidJokeCount, "count"), http.StatusBadRequest, CodeInvalidCount},
+		{"joke_not_found", ErrJokeNotFound, http.StatusNotFound, CodeJokeNotFound},
+		{"no_jokes_in_category", ErrNoJokesInCategory, http.StatusNotFound, CodeNoJokesInCategory},
+		{"names_rate_limited", ErrNamesApiTooManyRequests, http.StatusTooManyRequests, CodeRateLimited},
+		{"jokes_rate_limited", ErrJokesApiTooManyRequests, http.StatusTooManyRequests, CodeRateLimited},
+		{"names_exhausted", ErrNoNamesAvailable, http.StatusServiceUnavailable, CodeNamesExhausted},
+		{"no_pinned_name", ErrNoPinnedName, http.StatusGatewayTimeout, CodeNoCachedName},
+		{"breaker_open", &breaker.OpenError{Name: "jokes", RetryAt: time.Now().Add(time.Minute)}, http.StatusServiceUnavailable, CodeUpstreamUnavailable},
+		{"upstream_error", errors.Wrapf(ErrNon200JokeApiResponse, "status code %d", 500), http.StatusBadGateway, CodeUpstreamError},
+		{"unsuccessful_query", ErrUnsuccessfulJokeQuery, http.StatusBadGateway, CodeUpstreamBadPayload},
+		{"bad_json", errors.Wrap(json.Unmarshal([]byte(`{"invalid"`), &Joke{}), "unable to unmarshal"), http.StatusBadGateway, CodeUpstreamBadPayload},
+		{"name_not_substituted", errors.Wrap(ErrNameNotSubstituted, "no joke with a name"), http.StatusBadGateway, CodeUpstreamBadPayload},
+		{"deadline", context.DeadlineExceeded, http.StatusGatewayTimeout, CodeUpstreamTimeout},
+		{"canceled", context.Canceled, statusClientClosedRequest, CodeRequestCanceled},
+		{"canceled_upstream_request", errors.Wrap(&url.Error{Op: "Get", URL: "http://jokes", Err: context.Canceled}, "unable to get new joke"), statusClientClosedRequest, CodeRequestCanceled},
+		{"unknown", errors.New("something broke"), http.StatusInternalServerError, CodeInternal},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+			apiErr := toApiError(tt.err)
+			assert.Equal(tt.expStatus, apiErr.Status)
+			assert.Equal(tt.expCode, apiErr.Code)
+			assert.NotEmpty(apiErr.Message)
+		})
+	}
+}
+
+func TestErrorMessagesDontLeakInternals(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	err := errors.Wrap(errors.New("dial tcp 10.0.0.7:443: secret"), "unable to get new joke from 'http://internal'")
+	w := httptest.NewRecorder()
+	writeError(w, httptest.NewRequest("GET", "/", nil), contentTypeText, err)
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal("internal_error: internal server error\n", w.Body.String())
+}
+
+func TestWritingErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		contentType    string
+		err            error
+		expBody        string
+		expRetryAfter  string
+		expContentType string
+	}{
+		{"text", contentTypeText, ErrJokeNotFound, "joke_not_found: no joke exists with the requested ID\n", "", "text/plain; charset=utf-8"},
+		{"json", contentTypeJson, ErrJokeNotFound, `{"error":{"code":"joke_not_found","message":"no joke exists with the requested ID"}}` + "\n", "", contentTypeJson},
+		{"retry_after", contentTypeJson, withRetryAfter(ErrNamesApiTooManyRequests, &http.Response{Header: http.Header{"Retry-After": {"30"}}}),
+			`{"error":{"code":"rate_limited","message":"an upstream service is rate limiting the server, please try again later"}}` + "\n", "30", contentTypeJson},
+		{"breaker_retry_after", contentTypeText, &breaker.OpenError{Name: "jokes", RetryAt: time.Now().Add(10 * time.Millisecond)},
+			"upstream_unavailable: an upstream service is unavailable, please try again later\n", "1", "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+			w := httptest.NewRecorder()
+			writeError(w, httptest.NewRequest("GET", "/", nil), tt.contentType, tt.err)
+			assert.Equal(tt.expBody, w.Body.String())
+			assert.Equal(tt.expRetryAfter, w.Header().Get("Retry-After"))
+			assert.Equal(tt.expContentType, w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestServerMapsUpstreamFailures(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		expStatus int
+		expCode   string
+	}{
+		{"rate_limited", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}, http.StatusTooManyRequests, CodeRateLimited},
+		{"server_error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusBadGateway, CodeUpstreamError},
+		{"bad_payload", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `<html>not a joke</html>`)
+		}, http.StatusBadGateway, CodeUpstreamBadPayload},
+		{"timeout", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}, http.StatusGatewayTimeout, CodeUpstreamTimeout},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+			ts := httptest.NewServer(tt.handler)
+			defer ts.Close()
+			u, err := url.Parse(ts.URL)
+			assert.Nil(err)
+			jc := NewJokeClient(*u)
+			jc.HttpClient.Timeout = 20 * time.Millisecond
+			srv := Server{JokeClient: jc}
+
+			req := httptest.NewRequest("GET", "http://doesnt.matter/api/v1/joke?firstName=Bill", nil)
+			w := httptest.NewRecorder()
+			srv.GetCustomJokeJson(w, req)
+
+			// only the error is written, never a joke after it
+			var resp ErrorResponse
+			dec := json.NewDecoder(w.Body)
+			assert.Nil(dec.Decode(&resp))
+			assert.False(dec.More())
+			assert.Equal(tt.expStatus, w.Code)
+			assert.Equal(tt.expCode, resp.Error.Code)
+		})
+	}
+}
+
+func TestServerRespondsOnlyIfCachedWithJsonError(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	srv := Server{Names: make(chan Name), Pins: NewLRUCache(10, time.Hour)}
+	req := httptest.NewRequest("GET", "http://doesnt.matter/api/v1/joke", nil)
+	req.Header.Set("Cache-Control", "only-if-cached")
+	w := httptest.NewRecorder()
+	srv.GetCustomJokeJson(w, req)
+
+	var resp ErrorResponse
+	assert.Nil(json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(http.StatusGatewayTimeout, w.Code)
+	assert.Equal(CodeNoCachedName, resp.Error.Code)
+}
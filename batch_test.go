@@ -0,0 +1,246 @@
+package jokesontap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestJokeClientRequestsBatchInOneCall(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var calls int
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		path = r.URL.Path
+		fmt.Fprint(w, `{"type": "success", "value": [
+			{"id": 1, "joke": "Chuck Norris &quot;one&quot;"},
+			{"id": 2, "joke": "Chuck Norris two"}
+		]}`)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL + "/jokes/random")
+	assert.Nil(err)
+	names := []Name{{"Ada", "Lovelace"}, {"Grace", "Hopper"}}
+	jokes, err := NewJokeClient(*u).JokesWithCustomNames(context.Background(), names, CategoryFilter{})
+	assert.Nil(err)
+	assert.Equal(1, calls)
+	assert.Equal("/jokes/random/2", path)
+	if assert.Len(jokes, 2) {
+		assert.Equal(`Ada Lovelace "one"`, jokes[0].Joke)
+		assert.Equal("Grace Hopper two", jokes[1].Joke)
+	}
+
+	_, err = NewJokeClient(*u).JokesWithCustomNames(context.Background(), append(names, Name{"Alan", "Turing"}), CategoryFilter{})
+	assert.NotNil(err, "too few jokes returned by the API")
+}
+
+func TestJokeStoreBatchDoesNotRepeatJokes(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	store := categorizedStore(t)
+	jokes, err := store.JokesWithCustomNames([]Name{{"Ada", "Lovelace"}, {"Grace", "Hopper"}}, CategoryFilter{})
+	assert.Nil(err)
+	if assert.Len(jokes, 2) {
+		assert.NotEqual(jokes[0].ID, jokes[1].ID)
+	}
+
+	// with fewer jokes than names, jokes are repeated
+	jokes, err = store.JokesWithCustomNames([]Name{{"Ada", "Lovelace"}, {"Grace", "Hopper"}}, CategoryFilter{Include: []string{"nerdy"}})
+	assert.Nil(err)
+	assert.Len(jokes, 2)
+}
+
+func TestServerServesBatchOfJokes(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	names := make(chan Name, 10)
+	names <- Name{"Ada", "Lovelace"}
+	names <- Name{"Ada", "Lovelace"}
+	names <- Name{"Grace", "Hopper"}
+	names <- Name{"Alan", "Turing"}
+	srv := Server{JokeStore: categorizedStore(t), Names: names, MaxJokes: 5}
+
+	req := httptest.NewRequest("GET", "http://doesnt.matter/jokes?count=3", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	srv.GetJokes(w, req)
+	assert.Equal(http.StatusOK, w.Result().StatusCode)
+	var resp JokesResponse
+	assert.Nil(json.NewDecoder(w.Result().Body).Decode(&resp))
+	if assert.Len(resp.Jokes, 3) {
+		seen := make(map[string]bool)
+		for _, joke := range resp.Jokes {
+			seen[joke.FirstName+" "+joke.LastName] = true
+		}
+		assert.Len(seen, 3, "every joke should have a distinct name")
+	}
+	assert.Equal(0, len(names))
+}
+
+func TestServerServesBatchAsText(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	srv := Server{JokeStore: categorizedStore(t), Names: make(chan Name)}
+	req := httptest.NewRequest("GET", "http://doesnt.matter/jokes?count=2&firstName=Ada", nil)
+	w := httptest.NewRecorder()
+	srv.GetJokes(w, req)
+	body, err := ioutil.ReadAll(w.Result().Body)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, w.Result().StatusCode)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if assert.Len(lines, 2) {
+		for _, line := range lines {
+			assert.True(strings.HasPrefix(line, "Ada is"), line)
+		}
+	}
+}
+
+func TestServerRejectsInvalidJokeCount(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	srv := Server{JokeStore: categorizedStore(t), Names: make(chan Name), MaxJokes: 5}
+	for _, count := range []string{"0", "-1", "6", "many"} {
+		t.Run(count, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://doesnt.matter/jokes?count="+count, nil)
+			w := httptest.NewRecorder()
+			srv.GetJokes(w, req)
+			assert.Equal(http.StatusBadRequest, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestServerGivesBackNamesWhenBatchFails(t *testing.T) {
+	t.Parallel()
+
+	t.Run("too_few_names", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+
+		// only cached copies of the two fresh names are left once they're taken, which can't make three distinct names
+		names := make(chan Name, 10)
+		pending := NewPendingNames()
+		for _, name := range []Name{{"Ada", "Lovelace"}, {"Grace", "Hopper"}} {
+			names <- name
+			pending.Add(name)
+		}
+		srv := Server{JokeStore: categorizedStore(t), Names: names, Pending: pending, NameCache: NewLRUCache(10, 0), MaxJokes: 5}
+
+		req := httptest.NewRequest("GET", "http://doesnt.matter/jokes?count=3", nil)
+		w := httptest.NewRecorder()
+		srv.GetJokes(w, req)
+		assert.Equal(http.StatusServiceUnavailable, w.Result().StatusCode)
+		assert.Equal(2, len(names))
+		assert.Len(pending.Names(), 2, "names given back are pending again")
+	})
+
+	t.Run("jokes_unavailable", func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"type": "success", "value": []}`)
+		}))
+		defer ts.Close()
+		u, err := url.Parse(ts.URL)
+		assert.Nil(err)
+
+		names := make(chan Name, 10)
+		names <- Name{"Ada", "Lovelace"}
+		names <- Name{"Grace", "Hopper"}
+		srv := Server{JokeClient: NewJokeClient(*u), Names: names, MaxJokes: 5}
+
+		req := httptest.NewRequest("GET", "http://doesnt.matter/jokes?count=2", nil)
+		w := httptest.NewRecorder()
+		srv.GetJokes(w, req)
+		assert.NotEqual(http.StatusOK, w.Result().StatusCode)
+		assert.Equal(2, len(names))
+	})
+}
+
+func TestServerGivesBackNameWhenJokeFails(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+
+	names := make(chan Name, 10)
+	names <- Name{"Ada", "Lovelace"}
+	srv := Server{JokeClient: NewJokeClient(*u), Names: names}
+
+	req := httptest.NewRequest("GET", "http://doesnt.matter/", nil)
+	w := httptest.NewRecorder()
+	srv.GetCustomJoke(w, req)
+	assert.NotEqual(http.StatusOK, w.Result().StatusCode)
+	assert.Equal(1, len(names))
+}
+
+func TestJokeClientSkipsJokesWithoutPlaceholder(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if len(paths) == 1 {
+			fmt.Fprint(w, `{"type": "success", "value": [
+				{"id": 1, "joke": "Chuck Norris one"},
+				{"id": 2, "joke": "nobody to replace"},
+				{"id": 3, "joke": "Chuck Norris three"}
+			]}`)
+			return
+		}
+		fmt.Fprint(w, `{"type": "success", "value": [{"id": 4, "joke": "Chuck Norris four"}]}`)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL + "/jokes/random")
+	assert.Nil(err)
+	names := []Name{{"Ada", "Lovelace"}, {"Grace", "Hopper"}, {"Alan", "Turing"}}
+	jokes, err := NewJokeClient(*u).JokesWithCustomNames(context.Background(), names, CategoryFilter{})
+	assert.Nil(err)
+	assert.Equal([]string{"/jokes/random/3", "/jokes/random/1"}, paths, "the skipped joke is requested again")
+	if assert.Len(jokes, 3) {
+		assert.Equal("Ada Lovelace one", jokes[0].Joke)
+		assert.Equal("Grace Hopper three", jokes[1].Joke)
+		assert.Equal("Alan Turing four", jokes[2].Joke)
+	}
+}
+
+func TestJokeClientGivesUpOnJokesWithoutPlaceholder(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, `{"type": "success", "value": {"id": 2, "joke": "nobody to replace"}}`)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	_, err = NewJokeClient(*u).JokeWithCustomName(context.Background(), "Ada", "Lovelace", CategoryFilter{})
+	assert.Equal(ErrNameNotSubstituted, errors.Cause(err))
+	assert.Equal(placeholderAttempts, calls)
+}
@@ -0,0 +1,202 @@
+// Package breaker stops calling an upstream service which keeps failing, failing fast instead until the service has
+// had time to recover.
+package breaker
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// Closed breakers allow every operation.
+	Closed State = iota
+	// HalfOpen breakers allow a single trial operation to find out whether the upstream service has recovered.
+	HalfOpen
+	// Open breakers fail every operation at once.
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// Result is the result of an operation allowed by a Breaker.
+type Result int
+
+const (
+	// Success shows the upstream service is working.
+	Success Result = iota
+	// Failure shows the upstream service is failing.
+	Failure
+	// Ignored shows nothing about the upstream service, like when the operation was abandoned by the caller.  It
+	// counts as neither a success nor a failure, but frees a half-open breaker to allow another trial.
+	Ignored
+)
+
+// OpenError is returned for operations which are not allowed because the breaker is open.
+type OpenError struct {
+	// Name is the name of the breaker.
+	Name string
+	// RetryAt is the earliest time the breaker may allow another operation.
+	RetryAt time.Time
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %s is open, retry in %s", e.Name, time.Until(e.RetryAt).Round(time.Second))
+}
+
+// RetryAfter returns how long to wait before retrying if err, or the cause of err, is an OpenError.
+func RetryAfter(err error) (time.Duration, bool) {
+	open, ok := errors.Cause(err).(*OpenError)
+	if !ok {
+		return 0, false
+	}
+	return time.Until(open.RetryAt), true
+}
+
+// Breaker is a circuit breaker.  It opens after a number of consecutive failed operations, failing every operation
+// at once while open.  After a cool down the breaker is half-open and allows a single trial operation, closing again
+// if it succeeds or opening for another cool down if it fails.  A trial whose result is Ignored lets the next operation
+// be the trial instead.  A nil Breaker allows every operation.
+type Breaker struct {
+	// Name identifies the breaker in logs and errors.
+	Name string
+	// FailureThreshold is the number of consecutive failures which open the breaker.
+	FailureThreshold int
+	// Cooldown is how long the breaker stays open before allowing a trial operation.
+	Cooldown time.Duration
+	// OnStateChange, when set, is called each time the breaker changes state.  It must not call the breaker.
+	OnStateChange func(name string, from, to State)
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	// openedAt is when the breaker last opened.
+	openedAt time.Time
+	// trial is true while the trial operation of a half-open breaker is in progress.
+	trial bool
+	// generation increases with every change of state, so that results of operations allowed in an earlier state
+	// are ignored.
+	generation int
+}
+
+// New creates a closed Breaker which opens after threshold consecutive failures and stays open for cooldown.
+func New(name string, threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{
+		Name:             name,
+		FailureThreshold: threshold,
+		Cooldown:         cooldown,
+	}
+}
+
+// Allow checks whether an operation may run.  If it may, the returned func must be called with the result of the
+// operation.  Otherwise an *OpenError is returned.
+func (b *Breaker) Allow() (func(result Result), error) {
+	if b == nil {
+		return func(Result) {}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.state == Open {
+		retryAt := b.openedAt.Add(b.Cooldown)
+		if now.Before(retryAt) {
+			return nil, &OpenError{Name: b.Name, RetryAt: retryAt}
+		}
+		b.setState(HalfOpen)
+	}
+	if b.state == HalfOpen {
+		if b.trial {
+			// the trial should finish well within the cool down, by which time the breaker is closed or open again
+			return nil, &OpenError{Name: b.Name, RetryAt: now.Add(b.Cooldown)}
+		}
+		b.trial = true
+	}
+
+	generation := b.generation
+	return func(result Result) {
+		b.record(generation, result)
+	}, nil
+}
+
+// State gets the current state of the breaker.  An open breaker whose cool down has passed is reported as half-open.
+func (b *Breaker) State() State {
+	if b == nil {
+		return Closed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && !time.Now().Before(b.openedAt.Add(b.Cooldown)) {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// record counts the result of an operation allowed during generation.
+func (b *Breaker) record(generation int, result Result) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+	switch {
+	case result == Ignored:
+		// the trial, if this was it, told us nothing, so the next operation is allowed to try instead
+		b.trial = false
+	case result == Success:
+		b.failures = 0
+		if b.state == HalfOpen {
+			b.setState(Closed)
+		}
+	case b.state == HalfOpen:
+		b.open()
+	default:
+		b.failures++
+		if b.failures >= b.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+func (b *Breaker) open() {
+	b.openedAt = time.Now()
+	b.setState(Open)
+}
+
+// setState moves the breaker to state, starting a new generation.  The caller must hold the lock.
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+	b.failures = 0
+	b.trial = false
+	b.generation++
+
+	entry := log.WithFields(log.Fields{"breaker": b.Name, "from": from.String(), "to": state.String()})
+	if state == Open {
+		entry.WithField("cooldown", b.Cooldown).Warn("circuit breaker opened")
+	} else {
+		entry.Info("circuit breaker changed state")
+	}
+	if b.OnStateChange != nil {
+		b.OnStateChange(b.Name, from, state)
+	}
+}
@@ -0,0 +1,152 @@
+package breaker
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	b := New("test", 3, time.Hour)
+	fail := func() {
+		done, err := b.Allow()
+		assert.Nil(err)
+		done(Failure)
+	}
+
+	fail()
+	fail()
+	// a success resets the count of consecutive failures
+	done, err := b.Allow()
+	assert.Nil(err)
+	done(Success)
+	fail()
+	fail()
+	assert.Equal(Closed, b.State())
+
+	fail()
+	assert.Equal(Open, b.State())
+	_, err = b.Allow()
+	assert.IsType(&OpenError{}, err)
+}
+
+func TestBreakerAllowsSingleTrialWhenHalfOpen(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		trialResult Result
+		expState    State
+	}{
+		{Success, Closed},
+		{Failure, Open},
+		{Ignored, HalfOpen},
+	}
+
+	for _, tt := range tests {
+		assert := assert.New(t)
+		b := New("test", 1, 10*time.Millisecond)
+		done, err := b.Allow()
+		assert.Nil(err)
+		done(Failure)
+		assert.Equal(Open, b.State())
+
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(HalfOpen, b.State())
+		trial, err := b.Allow()
+		assert.Nil(err)
+		_, err = b.Allow()
+		assert.IsType(&OpenError{}, err, "only one trial should run at a time")
+
+		trial(tt.trialResult)
+		assert.Equal(tt.expState, b.State())
+	}
+}
+
+func TestBreakerAllowsAnotherTrialAfterIgnoredTrial(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	b := New("test", 1, 10*time.Millisecond)
+	done, err := b.Allow()
+	assert.Nil(err)
+	done(Failure)
+	time.Sleep(20 * time.Millisecond)
+
+	trial, err := b.Allow()
+	assert.Nil(err)
+	trial(Ignored)
+	assert.Equal(HalfOpen, b.State(), "an ignored trial must not close the breaker")
+
+	trial, err = b.Allow()
+	assert.Nil(err, "an ignored trial must free the breaker for another trial")
+	trial(Success)
+	assert.Equal(Closed, b.State())
+}
+
+func TestBreakerIgnoresResultsFromEarlierState(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	b := New("test", 1, time.Hour)
+	slow, err := b.Allow()
+	assert.Nil(err)
+	done, err := b.Allow()
+	assert.Nil(err)
+	done(Failure)
+	assert.Equal(Open, b.State())
+
+	// the slow operation started while closed, so its success must not close the breaker
+	slow(Success)
+	assert.Equal(Open, b.State())
+}
+
+func TestBreakerReportsStateChanges(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var changes []State
+	b := New("test", 1, time.Millisecond)
+	b.OnStateChange = func(name string, from, to State) {
+		assert.Equal("test", name)
+		changes = append(changes, to)
+	}
+	done, _ := b.Allow()
+	done(Failure)
+	time.Sleep(5 * time.Millisecond)
+	done, _ = b.Allow()
+	done(Success)
+
+	assert.Equal([]State{Open, HalfOpen, Closed}, changes)
+}
+
+func TestNilBreakerAllowsEverything(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var b *Breaker
+	for i := 0; i < 10; i++ {
+		done, err := b.Allow()
+		assert.Nil(err)
+		done(Failure)
+	}
+	assert.Equal(Closed, b.State())
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	open := &OpenError{Name: "test", RetryAt: time.Now().Add(time.Minute)}
+	retry, ok := RetryAfter(errors.Wrap(open, "wrapped"))
+	assert.True(ok)
+	assert.InDelta(time.Minute.Seconds(), retry.Seconds(), 1)
+
+	_, ok = RetryAfter(errors.New("not open"))
+	assert.False(ok)
+	_, ok = RetryAfter(nil)
+	assert.False(ok)
+}
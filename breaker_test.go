@@ -0,0 +1,157 @@
+package jokesontap
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/swtch1/jokesontap/breaker"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJokeClientFailsFastWhileBreakerIsOpen(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	jc := NewJokeClient(*u)
+	jc.Breaker = breaker.New("jokes", 2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := jc.Joke(context.Background())
+		assert.NotNil(err)
+		_, open := breaker.RetryAfter(err)
+		assert.False(open)
+	}
+	assert.Equal(breaker.Open, jc.Breaker.State())
+
+	before := atomic.LoadInt32(&requests)
+	_, err = jc.Joke(context.Background())
+	_, open := breaker.RetryAfter(err)
+	assert.True(open)
+	assert.Equal(before, atomic.LoadInt32(&requests), "no request should be made while the breaker is open")
+}
+
+func TestJokeClientBreakerIgnoresMissingJokes(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := jokesByIDApi()
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/jokes/random")
+	assert.Nil(err)
+	jc := NewJokeClient(*u)
+	jc.Breaker = breaker.New("jokes", 1, time.Minute)
+
+	_, err = jc.JokeByID(context.Background(), 3)
+	assert.Equal(ErrJokeNotFound, err)
+	assert.Equal(breaker.Closed, jc.Breaker.State())
+}
+
+func TestJokeClientCancelledTrialLeavesBreakerHalfOpen(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 2 {
+			// the trial hangs until the caller gives up on it
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	jc := NewJokeClient(*u)
+	jc.Retry.Retries = 0
+	jc.Breaker = breaker.New("jokes", 1, 10*time.Millisecond)
+
+	_, err = jc.Joke(context.Background())
+	assert.NotNil(err)
+	assert.Equal(breaker.Open, jc.Breaker.State())
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = jc.Joke(ctx)
+	assert.NotNil(err)
+	assert.Equal(breaker.HalfOpen, jc.Breaker.State(), "a cancelled trial must not close the breaker")
+
+	// the cancelled trial frees the breaker for the next one
+	_, err = jc.Joke(context.Background())
+	_, open := breaker.RetryAfter(err)
+	assert.False(open)
+	assert.Equal(int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestServerRespondsUnavailableWhileBreakerIsOpen(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/jokes/random")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name    string
+		target  string
+		handler func(*Server) http.HandlerFunc
+	}{
+		{"joke", "/?firstName=Bill", func(s *Server) http.HandlerFunc { return s.GetCustomJoke }},
+		{"jokes", "/jokes?count=2&firstName=Bill", func(s *Server) http.HandlerFunc { return s.GetJokes }},
+		{"joke by ID", "/jokes/1?firstName=Bill", func(s *Server) http.HandlerFunc { return s.GetJokeByID }},
+		{"categories", "/categories", func(s *Server) http.HandlerFunc { return s.GetCategories }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			jc := NewJokeClient(*u)
+			jc.Breaker = breaker.New("jokes", 1, time.Minute)
+			srv := &Server{JokeClient: jc}
+
+			w := httptest.NewRecorder()
+			tt.handler(srv)(w, httptest.NewRequest("GET", tt.target, nil))
+			assert.Equal(http.StatusBadGateway, w.Code)
+			assert.Empty(w.Header().Get("Retry-After"))
+
+			w = httptest.NewRecorder()
+			tt.handler(srv)(w, httptest.NewRequest("GET", tt.target, nil))
+			assert.Equal(http.StatusServiceUnavailable, w.Code)
+			retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+			assert.Nil(err)
+			assert.True(retry > 0 && retry <= 60, "retry after %d seconds should be within the cool down", retry)
+		})
+	}
+}
+
+func TestNameProvidersWaitForOpenBreaker(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	open := &breaker.OpenError{Name: "names:primary", RetryAt: time.Now().Add(time.Minute)}
+	primary := &stubNameRequester{name: "primary", err: open}
+	p := NewNameProviders(&NameProvider{Name: "primary", Requester: primary})
+
+	_, err := p.Names(context.Background())
+	assert.NotNil(err)
+	stats := p.Stats()[0]
+	assert.False(stats.Healthy)
+	assert.Equal(0, stats.Requests, "a provider behind an open breaker wasn't requested")
+	assert.True(stats.RetryAt.After(time.Now().Add(50 * time.Second)))
+}
@@ -0,0 +1,78 @@
+package budget
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a Budget which allows operations to run at a steady rate, with bursts up to the size of the
+// bucket.  Each operation spends a token and tokens are added back at the set rate.
+type TokenBucket struct {
+	// every is how often a new token is added to the bucket.
+	every time.Duration
+	// size is the maximum number of tokens the bucket holds.
+	size float64
+
+	mu     sync.Mutex
+	tokens float64
+	// last is when tokens was last brought up to date.
+	last time.Time
+}
+
+// NewTokenBucket creates a full TokenBucket which holds size tokens, adding a token back each time every elapses.
+func NewTokenBucket(size int, every time.Duration) *TokenBucket {
+	if size < 1 {
+		size = 1
+	}
+	return &TokenBucket{
+		every:  every,
+		size:   float64(size),
+		tokens: float64(size),
+		last:   time.Now(),
+	}
+}
+
+// Allow spends a token if one is available.
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wait blocks until a token is available.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	return wait(ctx, b)
+}
+
+// Next is when the next token will be available.
+func (b *TokenBucket) Next() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.refill(now)
+	if b.tokens >= 1 {
+		return now
+	}
+	return now.Add(time.Duration((1 - b.tokens) * float64(b.every)))
+}
+
+// refill adds the tokens earned since the bucket was last refilled.  The caller must hold the lock.
+func (b *TokenBucket) refill(now time.Time) {
+	if b.every > 0 {
+		b.tokens += float64(now.Sub(b.last)) / float64(b.every)
+	} else {
+		b.tokens = b.size
+	}
+	if b.tokens > b.size {
+		b.tokens = b.size
+	}
+	b.last = now
+}
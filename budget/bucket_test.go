@@ -0,0 +1,71 @@
+package budget
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllowsBursts(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"one", 1},
+		{"six", 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewTokenBucket(tt.size, time.Minute)
+			for i := 0; i < tt.size; i++ {
+				assert.True(b.Allow())
+			}
+			assert.False(b.Allow())
+			assert.WithinDuration(time.Now().Add(time.Minute), b.Next(), time.Second)
+		})
+	}
+}
+
+func TestTokenBucketRefillsAtRate(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	const every = time.Millisecond * 50
+	b := NewTokenBucket(2, every)
+	assert.True(b.Allow())
+	assert.True(b.Allow())
+	assert.False(b.Allow())
+
+	start := time.Now()
+	assert.Nil(b.Wait(context.Background()))
+	assert.True(time.Since(start) >= every/2)
+	assert.False(b.Allow())
+
+	// the bucket never holds more than its size
+	time.Sleep(every * 5)
+	assert.True(b.Allow())
+	assert.True(b.Allow())
+	assert.False(b.Allow())
+}
+
+func TestWaitingOnTokenBucketIsCancellable(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	b := NewTokenBucket(1, time.Hour)
+	assert.True(b.Allow())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, b.Wait(ctx))
+}
+
+func TestBudgetsImplementInterface(t *testing.T) {
+	t.Parallel()
+	var _ Budget = NewSlidingWindow(1, time.Second)
+	var _ Budget = NewTokenBucket(1, time.Second)
+}
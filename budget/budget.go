@@ -0,0 +1,36 @@
+// Package budget limits how often operations, like requests to a rate limited API, may be run.
+package budget
+
+import (
+	"context"
+	"time"
+)
+
+// Budget limits how often an operation may run.
+type Budget interface {
+	// Allow spends budget for a single operation and returns true if the budget allows an operation now,
+	// otherwise false is returned and nothing is spent.
+	Allow() bool
+	// Wait blocks until the budget allows an operation and then spends budget for it.  If ctx is done before
+	// then the context error is returned and nothing is spent.
+	Wait(ctx context.Context) error
+	// Next is the earliest time the budget will allow another operation.
+	Next() time.Time
+}
+
+// wait blocks until b allows an operation or ctx is done, sleeping until the next operation is allowed rather than
+// polling.
+func wait(ctx context.Context, b Budget) error {
+	for {
+		if b.Allow() {
+			return nil
+		}
+		timer := time.NewTimer(time.Until(b.Next()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package budget
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SlidingWindow is a Budget which allows at most a set number of operations within any window of time.
+type SlidingWindow struct {
+	// per is the length of the window.
+	per time.Duration
+
+	mu sync.Mutex
+	// requests keeps track of when operations were run.  The size of the slice is the maximum number of
+	// operations (the budget) that can be run within the window.
+	requests []time.Time
+	// pos is the current position in requests, a tracker for getting the oldest operation.
+	pos int
+}
+
+// NewSlidingWindow creates a SlidingWindow where ops operations can be run in any window of length per.
+func NewSlidingWindow(ops int, per time.Duration) *SlidingWindow {
+	if ops < 1 {
+		ops = 1
+	}
+	return &SlidingWindow{
+		per:      per,
+		requests: make([]time.Time, ops),
+	}
+}
+
+// Allow spends budget for an operation if the oldest operation in the window is at least the window length ago.
+func (w *SlidingWindow) Allow() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(w.next()) {
+		return false
+	}
+	w.requests[w.pos] = now
+	w.incPos()
+	return true
+}
+
+// Wait blocks until the oldest operation in the window falls out of the window.
+func (w *SlidingWindow) Wait(ctx context.Context) error {
+	return wait(ctx, w)
+}
+
+// Next is when the oldest operation in the window falls out of the window.
+func (w *SlidingWindow) Next() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.next()
+}
+
+// Recent gets the times of the operations in the window, oldest first.  Times are zero for operations which
+// have not been run yet.
+func (w *SlidingWindow) Recent() []time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	recent := make([]time.Time, 0, len(w.requests))
+	recent = append(recent, w.requests[w.pos:]...)
+	return append(recent, w.requests[:w.pos]...)
+}
+
+func (w *SlidingWindow) next() time.Time {
+	oldest := w.requests[w.pos]
+	if oldest.IsZero() {
+		return oldest
+	}
+	return oldest.Add(w.per)
+}
+
+// incPos increases the position counter, dropping back to 0 when the
+// end of the requests tracking slice is reached.
+func (w *SlidingWindow) incPos() {
+	if w.pos >= len(w.requests)-1 {
+		w.pos = 0
+	} else {
+		w.pos++
+	}
+}
@@ -0,0 +1,83 @@
+package budget
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowPositionNeverPanics(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	w := NewSlidingWindow(6, time.Minute)
+	for i := 0; i < len(w.requests)*2; i++ {
+		// basically just asserting that we aren't off by 1 which would eventually panic on an invalid index
+		assert.NotPanics(w.incPos)
+	}
+}
+
+func TestSlidingWindowAllowsBudgetWithinWindow(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		name string
+		ops  int
+	}{
+		{"one", 1},
+		{"six", 6},
+		{"many", 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewSlidingWindow(tt.ops, time.Minute)
+			for i := 0; i < tt.ops; i++ {
+				assert.True(w.Allow())
+			}
+			assert.False(w.Allow())
+			assert.WithinDuration(time.Now().Add(time.Minute), w.Next(), time.Second)
+		})
+	}
+}
+
+func TestSlidingWindowAllowsAgainAfterWindow(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	const per = time.Millisecond * 100
+	w := NewSlidingWindow(2, per)
+	assert.True(w.Allow())
+	assert.True(w.Allow())
+	assert.False(w.Allow())
+
+	start := time.Now()
+	assert.Nil(w.Wait(context.Background()))
+	assert.True(time.Since(start) >= per/2)
+	assert.Len(w.Recent(), 2)
+}
+
+func TestSlidingWindowRecentIsOldestFirst(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	w := NewSlidingWindow(3, time.Minute)
+	for i := 0; i < 4; i++ {
+		w.requests[w.pos] = time.Unix(int64(i), 0)
+		w.incPos()
+	}
+	assert.Equal([]time.Time{time.Unix(1, 0), time.Unix(2, 0), time.Unix(3, 0)}, w.Recent())
+}
+
+func TestWaitingOnSlidingWindowIsCancellable(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	w := NewSlidingWindow(1, time.Hour)
+	assert.True(w.Allow())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, w.Wait(ctx))
+}
@@ -0,0 +1,150 @@
+package jokesontap
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Cacher represents a cache.
+type Cacher interface {
+	// Add adds a value to the cache under key, returning true if an older entry was evicted to make room.
+	Add(key, value interface{}) bool
+	// Get gets the value stored under key, if it exists and has not expired.
+	Get(key interface{}) (interface{}, bool)
+	// Random gets any value from the cache which has not expired.
+	Random() (interface{}, bool)
+	// Len is the number of entries in the cache, including those that may have expired but not yet been removed.
+	Len() int
+}
+
+// LRUCache is a bounded, in memory Cacher.  Once the cache is full the least recently used entry is evicted
+// to make room for a new one, and entries expire once they are older than the TTL.
+type LRUCache struct {
+	// size is the maximum number of entries the cache will hold.
+	size int
+	// ttl is how long an entry may live in the cache.  Entries never expire when ttl is zero.
+	ttl time.Duration
+
+	mu sync.Mutex
+	// ll orders entries from most recently used at the front to least recently used at the back.
+	ll *list.List
+	// items indexes list elements by key.
+	items map[interface{}]*list.Element
+	// elems holds every list element so that a random entry can be picked in constant time.
+	elems []*list.Element
+}
+
+type cacheEntry struct {
+	key     interface{}
+	value   interface{}
+	expires time.Time
+	// idx is the position of this entry in elems.
+	idx int
+}
+
+// NewLRUCache creates an LRUCache which holds at most size entries, each for no longer than ttl.
+func NewLRUCache(size int, ttl time.Duration) *LRUCache {
+	if size < 1 {
+		size = 1
+	}
+	return &LRUCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[interface{}]*list.Element),
+	}
+}
+
+// Add adds value under key, evicting the least recently used entry if the cache is full.
+func (c *LRUCache) Add(key, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.value = value
+		entry.expires = c.expiry()
+		c.ll.MoveToFront(elem)
+		return false
+	}
+
+	evicted := false
+	if c.ll.Len() >= c.size {
+		c.remove(c.ll.Back())
+		evicted = true
+	}
+	entry := &cacheEntry{key: key, value: value, expires: c.expiry(), idx: len(c.elems)}
+	elem := c.ll.PushFront(entry)
+	c.items[key] = elem
+	c.elems = append(c.elems, elem)
+	return evicted
+}
+
+// Get gets the value under key and marks it as recently used.
+func (c *LRUCache) Get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	if c.expired(elem) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).value, true
+}
+
+// Random gets a random unexpired value and marks it as recently used.
+func (c *LRUCache) Random() (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.elems) > 0 {
+		elem := c.elems[rand.Intn(len(c.elems))]
+		if c.expired(elem) {
+			c.remove(elem)
+			continue
+		}
+		c.ll.MoveToFront(elem)
+		return elem.Value.(*cacheEntry).value, true
+	}
+	return nil, false
+}
+
+// Len is the number of entries in the cache.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRUCache) expiry() time.Time {
+	if c.ttl == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.ttl)
+}
+
+func (c *LRUCache) expired(elem *list.Element) bool {
+	expires := elem.Value.(*cacheEntry).expires
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+// remove removes elem from the cache.  The caller must hold the lock.
+func (c *LRUCache) remove(elem *list.Element) {
+	entry := c.ll.Remove(elem).(*cacheEntry)
+	delete(c.items, entry.key)
+
+	// swap the last element into the removed element's place
+	last := len(c.elems) - 1
+	moved := c.elems[last]
+	c.elems[entry.idx] = moved
+	moved.Value.(*cacheEntry).idx = entry.idx
+	c.elems[last] = nil
+	c.elems = c.elems[:last]
+}
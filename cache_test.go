@@ -0,0 +1,119 @@
+package jokesontap
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	c := NewLRUCache(2, 0)
+	assert.False(c.Add("a", 1))
+	assert.False(c.Add("b", 2))
+	// touch a so that b becomes the least recently used
+	_, ok := c.Get("a")
+	assert.True(ok)
+	assert.True(c.Add("c", 3))
+
+	assert.Equal(2, c.Len())
+	_, ok = c.Get("b")
+	assert.False(ok)
+	v, ok := c.Get("a")
+	assert.True(ok)
+	assert.Equal(1, v)
+	v, ok = c.Get("c")
+	assert.True(ok)
+	assert.Equal(3, v)
+}
+
+func TestLRUCacheUpdatesExistingKeys(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	c := NewLRUCache(2, 0)
+	c.Add("a", 1)
+	assert.False(c.Add("a", 2))
+	assert.Equal(1, c.Len())
+	v, ok := c.Get("a")
+	assert.True(ok)
+	assert.Equal(2, v)
+}
+
+func TestLRUCacheExpiresEntries(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	const ttl = time.Millisecond * 50
+	c := NewLRUCache(10, ttl)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	time.Sleep(ttl * 2)
+
+	_, ok := c.Get("a")
+	assert.False(ok)
+	_, ok = c.Random()
+	assert.False(ok)
+	assert.Equal(0, c.Len())
+}
+
+func TestLRUCacheRandomReturnsCachedValues(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	c := NewLRUCache(100, 0)
+	_, ok := c.Random()
+	assert.False(ok)
+
+	for i := 0; i < 150; i++ {
+		c.Add(i, i)
+	}
+	assert.Equal(100, c.Len())
+	for i := 0; i < 100; i++ {
+		v, ok := c.Random()
+		assert.True(ok)
+		// only the most recent 100 entries should remain
+		assert.True(v.(int) >= 50)
+	}
+}
+
+func TestNameClientCachesNames(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	up := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `[{"name": "Ada", "surname": "Lovelace"}]`)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+
+	nc := NewNameClient(*u)
+	_, ok := nc.CachedName()
+	assert.False(ok, "without a cache there are no cached names")
+
+	nc.Cache = NewLRUCache(10, time.Hour)
+	_, ok = nc.CachedName()
+	assert.False(ok)
+	_, err = nc.Names(context.Background())
+	assert.Nil(err)
+
+	up = false
+	_, err = nc.Names(context.Background())
+	assert.NotNil(err)
+	name, ok := nc.CachedName()
+	assert.True(ok)
+	assert.Equal(Name{Name: "Ada", Surname: "Lovelace"}, name)
+}
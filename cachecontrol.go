@@ -0,0 +1,122 @@
+package jokesontap
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// clientKeyHeader may be set by clients which would rather identify themselves than hold a cookie.
+	clientKeyHeader = "X-Client-Key"
+	// clientKeyCookie identifies a client across requests when no client key header is given.
+	clientKeyCookie = "jokesontap_client"
+)
+
+// cacheControl holds the request Cache-Control directives the server acts on.
+type cacheControl struct {
+	// maxAge is the oldest a reused name may be, in seconds, or -1 when not given.
+	maxAge int
+	// onlyIfCached is set when the client only wants a previously used name.
+	onlyIfCached bool
+}
+
+// wantsCached returns true when the client asked for a previously used name in any way.
+func (cc cacheControl) wantsCached() bool {
+	return cc.maxAge >= 0 || cc.onlyIfCached
+}
+
+// allows returns true when a name pinned age ago satisfies the directives.
+func (cc cacheControl) allows(age time.Duration) bool {
+	if cc.maxAge < 0 {
+		return cc.onlyIfCached
+	}
+	return age <= time.Duration(cc.maxAge)*time.Second
+}
+
+// parseCacheControl parses the Cache-Control request header, ignoring any directives we do not act on.
+func parseCacheControl(header string) cacheControl {
+	cc := cacheControl{maxAge: -1}
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "only-if-cached":
+			cc.onlyIfCached = true
+		case strings.HasPrefix(directive, "max-age="):
+			age, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(directive, "max-age="), `"`))
+			if err == nil && age >= 0 {
+				cc.maxAge = age
+			}
+		}
+	}
+	return cc
+}
+
+// pinnedName is the name last served to a client and when it was first served.
+type pinnedName struct {
+	Name Name
+	At   time.Time
+}
+
+// clientKey identifies the client making the request, preferring an explicit client key header over the client
+// cookie.  A new key is created for clients without either, and a cookie is set so the client can be identified
+// on subsequent requests.
+func (s *Server) clientKey(w http.ResponseWriter, req *http.Request) string {
+	if key := req.Header.Get(clientKeyHeader); key != "" {
+		return key
+	}
+	if cookie, err := req.Cookie(clientKeyCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	key := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     clientKeyCookie,
+		Value:    key,
+		Path:     "/",
+		HttpOnly: true,
+	})
+	return key
+}
+
+// pinnedName gets the name last served to the client with the given key.
+func (s *Server) pinnedName(key string) (pinnedName, bool) {
+	if s.Pins == nil || key == "" {
+		return pinnedName{}, false
+	}
+	v, ok := s.Pins.Get(key)
+	if !ok {
+		return pinnedName{}, false
+	}
+	return v.(pinnedName), true
+}
+
+// pinName remembers name as the name last served to the client with the given key.
+func (s *Server) pinName(key string, name Name) {
+	if s.Pins == nil || key == "" {
+		return
+	}
+	s.Pins.Add(key, pinnedName{Name: name, At: time.Now()})
+}
+
+// setCacheHeaders sets the Cache-Control and Age response headers to match the request directives for a name
+// pinned age ago.
+func setCacheHeaders(w http.ResponseWriter, cc cacheControl, age time.Duration) {
+	if !cc.wantsCached() {
+		return
+	}
+	// the name is specific to this client, so shared caches must not store the response
+	if cc.maxAge >= 0 {
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(cc.maxAge))
+	} else {
+		w.Header().Set("Cache-Control", "private")
+	}
+	w.Header().Set("Age", strconv.Itoa(int(age/time.Second)))
+}
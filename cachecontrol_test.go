@@ -0,0 +1,151 @@
+package jokesontap
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParsingCacheControl(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		header          string
+		expMaxAge       int
+		expOnlyIfCached bool
+	}{
+		{"", -1, false},
+		{"no-cache", -1, false},
+		{"max-age=60", 60, false},
+		{"Max-Age=60", 60, false},
+		{`max-age="30"`, 30, false},
+		{"max-age=-5", -1, false},
+		{"max-age=abc", -1, false},
+		{"only-if-cached", -1, true},
+		{"no-transform, max-age=10 ,only-if-cached", 10, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			cc := parseCacheControl(tt.header)
+			assert.Equal(tt.expMaxAge, cc.maxAge)
+			assert.Equal(tt.expOnlyIfCached, cc.onlyIfCached)
+		})
+	}
+}
+
+func TestCacheControlAllowsPinnedAge(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	assert.True(parseCacheControl("max-age=60").allows(time.Second * 59))
+	assert.False(parseCacheControl("max-age=60").allows(time.Second * 61))
+	assert.True(parseCacheControl("only-if-cached").allows(time.Hour))
+	assert.False(parseCacheControl("").allows(0))
+}
+
+func TestServerPinsNamesToClients(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, `{"type": "success", "value": { "joke": "Chuck Norris is pinned."}}`)
+		assert.Nil(err)
+	}))
+	defer ts.Close()
+
+	jokeUrl, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	nameChan := make(chan Name, 10)
+	nameChan <- Name{Name: "Bill", Surname: "Murray"}
+	nameChan <- Name{Name: "Ada", Surname: "Lovelace"}
+	srv := Server{
+		JokeClient: NewJokeClient(*jokeUrl),
+		Names:      nameChan,
+		Pins:       NewLRUCache(10, 0),
+	}
+
+	get := func(cacheControl string) *http.Response {
+		req := httptest.NewRequest("GET", "http://doesnt.matter", nil)
+		req.Header.Set(clientKeyHeader, "client-a")
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		w := httptest.NewRecorder()
+		srv.GetCustomJoke(w, req)
+		return w.Result()
+	}
+	body := func(resp *http.Response) string {
+		b, err := ioutil.ReadAll(resp.Body)
+		assert.Nil(err)
+		return string(b)
+	}
+
+	resp := get("")
+	assert.Equal("Bill Murray is pinned.\n", body(resp))
+	assert.Empty(resp.Header.Get("Age"))
+
+	// the pinned name is reused without consuming a fresh name
+	resp = get("max-age=60")
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("Bill Murray is pinned.\n", body(resp))
+	assert.Equal("private, max-age=60", resp.Header.Get("Cache-Control"))
+	assert.Equal("0", resp.Header.Get("Age"))
+	assert.Equal(1, len(nameChan))
+
+	resp = get("only-if-cached")
+	assert.Equal("Bill Murray is pinned.\n", body(resp))
+	assert.Equal("private", resp.Header.Get("Cache-Control"))
+
+	// without a directive the client gets a fresh name, which then becomes its pinned name
+	resp = get("")
+	assert.Equal("Ada Lovelace is pinned.\n", body(resp))
+	resp = get("max-age=60")
+	assert.Equal("Ada Lovelace is pinned.\n", body(resp))
+}
+
+func TestServerOnlyIfCachedWithoutPinnedName(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	nameChan := make(chan Name, 1)
+	nameChan <- Name{Name: "Bill", Surname: "Murray"}
+	srv := Server{
+		Names: nameChan,
+		Pins:  NewLRUCache(10, 0),
+	}
+
+	req := httptest.NewRequest("GET", "http://doesnt.matter", nil)
+	req.Header.Set("Cache-Control", "only-if-cached")
+	w := httptest.NewRecorder()
+	srv.GetCustomJoke(w, req)
+	assert.Equal(http.StatusGatewayTimeout, w.Result().StatusCode)
+	// the fresh name must not have been consumed
+	assert.Equal(1, len(nameChan))
+	// a cookie is set so the client can be identified next time
+	cookies := w.Result().Cookies()
+	assert.Len(cookies, 1)
+	assert.Equal(clientKeyCookie, cookies[0].Name)
+	assert.NotEmpty(cookies[0].Value)
+}
+
+func TestClientKeyPrefersHeaderOverCookie(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	srv := Server{}
+	req := httptest.NewRequest("GET", "http://doesnt.matter", nil)
+	req.AddCookie(&http.Cookie{Name: clientKeyCookie, Value: "from-cookie"})
+	w := httptest.NewRecorder()
+	assert.Equal("from-cookie", srv.clientKey(w, req))
+
+	req.Header.Set(clientKeyHeader, "from-header")
+	assert.Equal("from-header", srv.clientKey(w, req))
+	assert.Empty(w.Result().Cookies())
+}
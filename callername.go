@@ -0,0 +1,85 @@
+package jokesontap
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// userNameHeader holds the full name of the user, as set by a trusted proxy in front of the server.
+	userNameHeader = "X-User-Name"
+	// maxNameLength is the most characters allowed in a caller supplied first or last name.
+	maxNameLength = 50
+)
+
+var ErrInvalidName = errors.New("invalid name")
+
+// validName matches names made of letters, accents, spaces, hyphens, apostrophes and periods, starting with a
+// letter.  Anything which could be markup, like angle brackets or HTML entities, is rejected.
+var validName = regexp.MustCompile(`^\p{L}[\p{L}\p{M} .'’-]*$`)
+
+// callerName gets the name the caller asked for, from the firstName and lastName query parameters or, when
+// trustHeader is true, from the X-User-Name header.  Query parameters take precedence over the header.  If the
+// caller didn't ask for a name false is returned.  An ErrInvalidName error is returned if the name is not valid.
+func callerName(req *http.Request, trustHeader bool) (Name, bool, error) {
+	var name Name
+	query := req.URL.Query()
+	_, hasFirst := query["firstName"]
+	_, hasLast := query["lastName"]
+	switch {
+	case hasFirst || hasLast:
+		name = Name{
+			Name:    strings.TrimSpace(query.Get("firstName")),
+			Surname: strings.TrimSpace(query.Get("lastName")),
+		}
+	case trustHeader && req.Header.Get(userNameHeader) != "":
+		// everything after the first name is the last name, for those with more than one.  A header holding only
+		// whitespace leaves the name empty, which is rejected below.
+		parts := strings.Fields(req.Header.Get(userNameHeader))
+		if len(parts) > 0 {
+			name = Name{
+				Name:    parts[0],
+				Surname: strings.Join(parts[1:], " "),
+			}
+		}
+	default:
+		return Name{}, false, nil
+	}
+
+	if name.Name == "" && name.Surname == "" {
+		return Name{}, true, errors.Wrap(ErrInvalidName, "a first or last name is required")
+	}
+	for _, part := range []struct {
+		desc  string
+		value string
+	}{
+		{"first name", name.Name},
+		{"last name", name.Surname},
+	} {
+		if err := validateNamePart(part.value); err != nil {
+			return Name{}, true, errors.Wrap(err, part.desc)
+		}
+	}
+	return name, true, nil
+}
+
+// validateNamePart returns an ErrInvalidName error if a single part of a name is not valid.  Empty parts are valid.
+func validateNamePart(part string) error {
+	if part == "" {
+		return nil
+	}
+	if !utf8.ValidString(part) {
+		return errors.Wrap(ErrInvalidName, "must be valid UTF-8")
+	}
+	if utf8.RuneCountInString(part) > maxNameLength {
+		return errors.Wrap(ErrInvalidName, fmt.Sprintf("must be at most %d characters", maxNameLength))
+	}
+	if !validName.MatchString(part) {
+		return errors.Wrap(ErrInvalidName, "must start with a letter and contain only letters, spaces, hyphens, apostrophes and periods")
+	}
+	return nil
+}
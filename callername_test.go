@@ -0,0 +1,100 @@
+package jokesontap
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCallerName(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		name        string
+		query       string
+		header      string
+		trustHeader bool
+		expName     Name
+		expGiven    bool
+		expErr      bool
+	}{
+		{"none", "", "", false, Name{}, false, false},
+		{"query", "firstName=Ada&lastName=Lovelace", "", false, Name{"Ada", "Lovelace"}, true, false},
+		{"first_name_only", "firstName=Cher", "", false, Name{"Cher", ""}, true, false},
+		{"accents_and_punctuation", "firstName=Zoë&lastName=O'Brien-Smith", "", false, Name{"Zoë", "O'Brien-Smith"}, true, false},
+		{"header", "", "Grace Brewster Hopper", true, Name{"Grace", "Brewster Hopper"}, true, false},
+		{"untrusted_header", "", "Grace Hopper", false, Name{}, false, false},
+		{"query_over_header", "firstName=Ada&lastName=Lovelace", "Grace Hopper", true, Name{"Ada", "Lovelace"}, true, false},
+		{"empty", "firstName=&lastName=", "", false, Name{}, true, true},
+		{"too_long", "firstName=" + strings.Repeat("a", maxNameLength+1), "", false, Name{}, true, true},
+		{"html", "firstName=%3Cscript%3E", "", false, Name{}, true, true},
+		{"html_entity", "firstName=Ada&lastName=%26amp%3B", "", false, Name{}, true, true},
+		{"digits", "firstName=R2D2", "", false, Name{}, true, true},
+		{"leading_punctuation", "firstName=-Ada", "", false, Name{}, true, true},
+		{"invalid_header", "", "<b>Grace</b>", true, Name{}, true, true},
+		{"blank_header", "", " ", true, Name{}, true, true},
+		{"nbsp_header", "", "\u00a0\t\u00a0", true, Name{}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://doesnt.matter/?"+tt.query, nil)
+			if tt.header != "" {
+				req.Header.Set(userNameHeader, tt.header)
+			}
+			name, given, err := callerName(req, tt.trustHeader)
+			assert.Equal(tt.expGiven, given)
+			if tt.expErr {
+				assert.Equal(ErrInvalidName, errors.Cause(err))
+				return
+			}
+			assert.Nil(err)
+			assert.Equal(tt.expName, name)
+		})
+	}
+}
+
+func TestServerUsesCallerName(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, `{"type": "success", "value": { "joke": "Chuck Norris counts to infinity twice."}}`)
+		assert.Nil(err)
+	}))
+	defer ts.Close()
+
+	jokeUrl, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	nameChan := make(chan Name, 1)
+	nameChan <- Name{Name: "Bill", Surname: "Murray"}
+	srv := Server{
+		JokeClient: NewJokeClient(*jokeUrl),
+		Names:      nameChan,
+		Pins:       NewLRUCache(10, 0),
+	}
+
+	req := httptest.NewRequest("GET", "http://doesnt.matter/?firstName=Ada&lastName=Lovelace", nil)
+	w := httptest.NewRecorder()
+	srv.GetCustomJoke(w, req)
+	body, err := ioutil.ReadAll(w.Result().Body)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, w.Result().StatusCode)
+	assert.Equal("Ada Lovelace counts to infinity twice.\n", string(body))
+	// the random names are left for everyone else
+	assert.Equal(1, len(nameChan))
+	assert.Equal(0, srv.Pins.Len())
+
+	req = httptest.NewRequest("GET", "http://doesnt.matter/?firstName=%3Cb%3E", nil)
+	w = httptest.NewRecorder()
+	srv.GetCustomJoke(w, req)
+	assert.Equal(http.StatusBadRequest, w.Result().StatusCode)
+	assert.Equal(1, len(nameChan))
+}
@@ -0,0 +1,104 @@
+package jokesontap
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidCategory   = errors.New("invalid category")
+	ErrNoJokesInCategory = errors.New("no jokes match the requested categories")
+)
+
+// validCategory matches category names as used by the jokes API.
+var validCategory = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// CategoryFilter limits jokes by category.
+type CategoryFilter struct {
+	// Include limits jokes to those in any of the categories.  Jokes in any category are allowed when empty.
+	Include []string
+	// Exclude removes jokes in any of the categories.
+	Exclude []string
+}
+
+// empty returns true if the filter allows every joke.
+func (f CategoryFilter) empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// allows returns true if a joke in the given categories passes the filter.
+func (f CategoryFilter) allows(categories []string) bool {
+	if len(f.Include) > 0 && !anyOf(categories, f.Include) {
+		return false
+	}
+	return !anyOf(categories, f.Exclude)
+}
+
+// anyOf returns true if any of values is in set.
+func anyOf(values, set []string) bool {
+	for _, v := range values {
+		for _, s := range set {
+			if v == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// categoryFilter gets the category filter for a request from the category and exclude query parameters, which may
+// be repeated or hold comma separated lists.  Jokes are limited to the defaults when the request doesn't name any
+// categories.  An ErrInvalidCategory error is returned if any category is not valid.
+func categoryFilter(req *http.Request, defaults []string) (CategoryFilter, error) {
+	query := req.URL.Query()
+	include, err := categoryParam(query["category"])
+	if err != nil {
+		return CategoryFilter{}, err
+	}
+	exclude, err := categoryParam(query["exclude"])
+	if err != nil {
+		return CategoryFilter{}, err
+	}
+	if len(include) == 0 {
+		include = defaults
+	}
+	return CategoryFilter{Include: include, Exclude: exclude}, nil
+}
+
+// categoryParam gets the categories from every value of a query parameter.
+func categoryParam(values []string) ([]string, error) {
+	var categories []string
+	for _, v := range values {
+		for _, c := range strings.Split(v, ",") {
+			c = strings.ToLower(strings.TrimSpace(c))
+			if c == "" {
+				continue
+			}
+			if !validCategory.MatchString(c) {
+				return nil, errors.Wrap(ErrInvalidCategory, fmt.Sprintf("'%s' should contain only letters, digits, hyphens and underscores", c))
+			}
+			categories = append(categories, c)
+		}
+	}
+	return categories, nil
+}
+
+// uniqueCategories gets the distinct categories of jokes, sorted.
+func uniqueCategories(jokes []JokeValue) []string {
+	seen := make(map[string]bool)
+	categories := []string{}
+	for _, joke := range jokes {
+		for _, c := range joke.Categories {
+			if !seen[c] {
+				seen[c] = true
+				categories = append(categories, c)
+			}
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
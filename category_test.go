@@ -0,0 +1,194 @@
+package jokesontap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCategoryFilterFromRequest(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	defaults := []string{"nerdy"}
+	tests := []struct {
+		name      string
+		query     string
+		expFilter CategoryFilter
+		expErr    bool
+	}{
+		{"defaults", "", CategoryFilter{Include: defaults}, false},
+		{"single", "category=explicit", CategoryFilter{Include: []string{"explicit"}}, false},
+		{"repeated", "category=explicit&category=nerdy", CategoryFilter{Include: []string{"explicit", "nerdy"}}, false},
+		{"comma_separated", "category=Explicit,%20nerdy", CategoryFilter{Include: []string{"explicit", "nerdy"}}, false},
+		{"exclude", "exclude=explicit", CategoryFilter{Include: defaults, Exclude: []string{"explicit"}}, false},
+		{"invalid", "category=%3Cb%3E", CategoryFilter{}, true},
+		{"invalid_exclude", "exclude=a%20b", CategoryFilter{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://doesnt.matter/?"+tt.query, nil)
+			filter, err := categoryFilter(req, defaults)
+			if tt.expErr {
+				assert.Equal(ErrInvalidCategory, errors.Cause(err))
+				return
+			}
+			assert.Nil(err)
+			assert.Equal(tt.expFilter, filter)
+		})
+	}
+}
+
+func TestCategoryFilterAllows(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		name       string
+		filter     CategoryFilter
+		categories []string
+		exp        bool
+	}{
+		{"empty_filter", CategoryFilter{}, []string{"explicit"}, true},
+		{"uncategorized", CategoryFilter{}, nil, true},
+		{"included", CategoryFilter{Include: []string{"nerdy"}}, []string{"nerdy"}, true},
+		{"not_included", CategoryFilter{Include: []string{"nerdy"}}, []string{"explicit"}, false},
+		{"uncategorized_not_included", CategoryFilter{Include: []string{"nerdy"}}, nil, false},
+		{"excluded", CategoryFilter{Exclude: []string{"explicit"}}, []string{"nerdy", "explicit"}, false},
+		{"included_and_excluded", CategoryFilter{Include: []string{"nerdy"}, Exclude: []string{"explicit"}}, []string{"nerdy", "explicit"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(tt.exp, tt.filter.allows(tt.categories))
+		})
+	}
+}
+
+// categorizedStore creates a JokeStore holding one nerdy and one explicit joke.
+func categorizedStore(t *testing.T) *JokeStore {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"type": "success", "value": [
+			{"id": 1, "joke": "Chuck Norris is nerdy.", "categories": ["nerdy"]},
+			{"id": 2, "joke": "Chuck Norris is explicit.", "categories": ["explicit"]}
+		]}`)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewJokeStore(*u)
+	if err := store.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestJokeStoreFiltersCategories(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	store := categorizedStore(t)
+	assert.Equal([]string{"explicit", "nerdy"}, store.Categories())
+	for i := 0; i < 10; i++ {
+		joke, err := store.JokeWithCustomName("Ada", "Lovelace", CategoryFilter{Include: []string{"nerdy"}})
+		assert.Nil(err)
+		assert.Equal("Ada Lovelace is nerdy.", joke.Joke)
+
+		joke, err = store.JokeWithCustomName("Ada", "Lovelace", CategoryFilter{Exclude: []string{"nerdy"}})
+		assert.Nil(err)
+		assert.Equal("Ada Lovelace is explicit.", joke.Joke)
+	}
+	_, err := store.JokeWithCustomName("Ada", "Lovelace", CategoryFilter{Include: []string{"sports"}})
+	assert.Equal(ErrNoJokesInCategory, err)
+}
+
+func TestJokeClientListsCategories(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprint(w, `{"type": "success", "value": ["nerdy", "explicit"]}`)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL + "/jokes/random")
+	assert.Nil(err)
+	categories, err := NewJokeClient(*u).Categories(context.Background())
+	assert.Nil(err)
+	assert.Equal("/categories", path)
+	assert.Equal([]string{"explicit", "nerdy"}, categories)
+}
+
+func TestServerServesCategories(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	srv := Server{JokeStore: categorizedStore(t), Names: make(chan Name)}
+
+	req := httptest.NewRequest("GET", "http://doesnt.matter/categories", nil)
+	w := httptest.NewRecorder()
+	srv.GetCategories(w, req)
+	body, err := ioutil.ReadAll(w.Result().Body)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, w.Result().StatusCode)
+	assert.Equal("explicit\nnerdy\n", string(body))
+
+	req = httptest.NewRequest("GET", "http://doesnt.matter/categories", nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	srv.GetCategories(w, req)
+	var resp CategoriesResponse
+	assert.Nil(json.NewDecoder(w.Result().Body).Decode(&resp))
+	assert.Equal([]string{"explicit", "nerdy"}, resp.Categories)
+}
+
+func TestServerServesRequestedCategory(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	names := make(chan Name, 10)
+	for i := 0; i < cap(names); i++ {
+		names <- Name{Name: "Bill", Surname: "Murray"}
+	}
+	srv := Server{JokeStore: categorizedStore(t), Names: names, Categories: []string{"nerdy"}}
+
+	tests := []struct {
+		name      string
+		query     string
+		expStatus int
+		expBody   string
+	}{
+		{"default", "", http.StatusOK, "Bill Murray is nerdy.\n"},
+		{"requested", "?category=explicit", http.StatusOK, "Bill Murray is explicit.\n"},
+		{"excluded", "?category=nerdy,explicit&exclude=nerdy", http.StatusOK, "Bill Murray is explicit.\n"},
+		{"unknown", "?category=sports", http.StatusNotFound, CodeNoJokesInCategory + ": " + ErrNoJokesInCategory.Error() + "\n"},
+		{"invalid", "?category=%3Cb%3E", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://doesnt.matter/"+tt.query, nil)
+			w := httptest.NewRecorder()
+			srv.GetCustomJoke(w, req)
+			body, err := ioutil.ReadAll(w.Result().Body)
+			assert.Nil(err)
+			assert.Equal(tt.expStatus, w.Result().StatusCode)
+			if tt.expBody != "" {
+				assert.Equal(tt.expBody, string(body))
+			}
+		})
+	}
+}
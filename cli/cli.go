@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/swtch1/jokesontap/config"
 	"os"
 )
 
@@ -18,22 +19,53 @@ var (
 		},
 	}
 
-	Help                bool
-	Version             bool
-	Port                int32
-	LogLevel            string
-	LogFormat           string
-	PrettyPrintJsonLogs bool
+	Help       bool
+	Version    bool
+	ConfigFile string
+
+	// flags holds the values of all configuration flags, only those explicitly set are applied.
+	flags config.Config
 )
 
 // Init performs setup for the application CLI commands and flags, setting application version as provided.
-func Init(version string) {
+// Configuration flags show the defaults given.
+func Init(version string, defaults config.Config) {
+	flags = defaults
 	cmd.PersistentFlags().BoolVarP(&Help, "help", "h", false, "Display this help and exit.")
 	cmd.PersistentFlags().BoolVar(&Version, "version", false, "Print the application version and exit.")
-	cmd.PersistentFlags().Int32VarP(&Port, "port", "p", 5000, "Port which the server will listen on.")
-	cmd.PersistentFlags().StringVarP(&LogLevel, "log-level", "l", "info", "Log level should be one of trace, debug, info, warn, error, fatal.")
-	cmd.PersistentFlags().StringVar(&LogFormat, "log-format", "text", "Log format should be one of text, json.")
-	cmd.PersistentFlags().BoolVar(&PrettyPrintJsonLogs, "pretty-json", false, "If writing JSON logs, pretty print those logs.")
+	cmd.PersistentFlags().StringVarP(&ConfigFile, "config", "c", os.Getenv(config.EnvPrefix+"CONFIG"), fmt.Sprintf("Path to a JSON or YAML config file. May also be set with %sCONFIG.", config.EnvPrefix))
+	cmd.PersistentFlags().Int32VarP(&flags.Port, "port", "p", flags.Port, "Port which the server will listen on.")
+	cmd.PersistentFlags().IntVar(&flags.MetricsPort, "metrics-port", flags.MetricsPort, "Port where Prometheus metrics are served at /metrics. Set to 0 to disable metrics.")
+	cmd.PersistentFlags().IntVar(&flags.AdminPort, "admin-port", flags.AdminPort, "Port where admin endpoints like /debug/status are served. Set to 0 to disable them.")
+	cmd.PersistentFlags().DurationVar(&flags.ShutdownGrace.Duration, "shutdown-grace", flags.ShutdownGrace.Duration, "How long in-flight requests are given to complete when the server is stopping.")
+	cmd.PersistentFlags().BoolVar(&flags.TrustUserNameHeader, "trust-user-name-header", flags.TrustUserNameHeader, "Serve jokes about the user named in the X-User-Name header. Only enable this behind a proxy which sets the header itself.")
+	cmd.PersistentFlags().StringVarP(&flags.Log.Level, "log-level", "l", flags.Log.Level, "Log level should be one of trace, debug, info, warn, error, fatal.")
+	cmd.PersistentFlags().StringVar(&flags.Log.Format, "log-format", flags.Log.Format, "Log format should be one of text, json.")
+	cmd.PersistentFlags().BoolVar(&flags.Log.PrettyJson, "pretty-json", flags.Log.PrettyJson, "If writing JSON logs, pretty print those logs.")
+	cmd.PersistentFlags().StringVar(&flags.Log.Access, "access-log", flags.Log.Access, "Access log format written to stdout should be one of common, combined, json, off.")
+	cmd.PersistentFlags().DurationVar(&flags.Log.RevertAfter.Duration, "log-revert-after", flags.Log.RevertAfter.Duration, "How long a log level or format changed at runtime lasts. Set to 0 to keep changes until reverted.")
+	cmd.PersistentFlags().StringVar(&flags.Names.Url, "names-url", flags.Names.Url, "URL of the names API.")
+	cmd.PersistentFlags().IntVar(&flags.Names.ChanSize, "names-chan-size", flags.Names.ChanSize, "Number of names eagerly retrieved and held ready for requests.")
+	cmd.PersistentFlags().IntVar(&flags.Names.ReadyThreshold, "names-ready-threshold", flags.Names.ReadyThreshold, "Number of names held ready before the server first reports that it is ready on /readyz.")
+	cmd.PersistentFlags().IntVar(&flags.Names.Budget, "names-budget", flags.Names.Budget, "Maximum number of names API requests allowed within the names budget window.")
+	cmd.PersistentFlags().DurationVar(&flags.Names.BudgetWindow.Duration, "names-budget-window", flags.Names.BudgetWindow.Duration, "Window of time in which the names budget applies.")
+	cmd.PersistentFlags().StringVar(&flags.Names.BudgetKind, "names-budget-kind", flags.Names.BudgetKind, "Names budget should be one of sliding, token.")
+	cmd.PersistentFlags().IntVar(&flags.Names.Breaker.Threshold, "names-breaker-threshold", flags.Names.Breaker.Threshold, "Number of consecutive failed names API requests which open its circuit breaker.")
+	cmd.PersistentFlags().DurationVar(&flags.Names.Breaker.Cooldown.Duration, "names-breaker-cooldown", flags.Names.Breaker.Cooldown.Duration, "How long an open names API circuit breaker waits before allowing a trial request.")
+	cmd.PersistentFlags().IntVar(&flags.Names.Cache.Size, "name-cache-size", flags.Names.Cache.Size, "Maximum number of served names kept for reuse when no fresh names are available.")
+	cmd.PersistentFlags().DurationVar(&flags.Names.Cache.TTL.Duration, "name-cache-ttl", flags.Names.Cache.TTL.Duration, "How long a served name may be reused for.")
+	cmd.PersistentFlags().StringVar(&flags.Names.Snapshot, "names-snapshot", flags.Names.Snapshot, "File where unconsumed names are kept across restarts. Set to an empty string to disable.")
+	cmd.PersistentFlags().DurationVar(&flags.Names.SnapshotInterval.Duration, "names-snapshot-interval", flags.Names.SnapshotInterval.Duration, "How often unconsumed names are saved to the names snapshot.")
+	cmd.PersistentFlags().IntVar(&flags.ClientPins.Size, "client-pin-size", flags.ClientPins.Size, "Maximum number of clients whose last name is remembered for Cache-Control requests.")
+	cmd.PersistentFlags().DurationVar(&flags.ClientPins.TTL.Duration, "client-pin-ttl", flags.ClientPins.TTL.Duration, "How long the last name served to a client is remembered.")
+	cmd.PersistentFlags().StringVar(&flags.Jokes.Url, "jokes-url", flags.Jokes.Url, "URL of the jokes API which returns a single random joke.")
+	cmd.PersistentFlags().StringVar(&flags.Jokes.CorpusUrl, "jokes-corpus-url", flags.Jokes.CorpusUrl, "URL of the jokes API which returns every joke, and under which each joke is found by its ID.")
+	cmd.PersistentFlags().StringVar(&flags.Jokes.CategoriesUrl, "jokes-categories-url", flags.Jokes.CategoriesUrl, "URL of the jokes API which lists every joke category.")
+	cmd.PersistentFlags().StringSliceVar(&flags.Jokes.Categories, "jokes-categories", flags.Jokes.Categories, "Categories of jokes served when a request doesn't ask for any. Jokes in every category are served when empty.")
+	cmd.PersistentFlags().IntVar(&flags.Jokes.MaxBatch, "jokes-max-batch", flags.Jokes.MaxBatch, "Most jokes served by a single request to /jokes.")
+	cmd.PersistentFlags().DurationVar(&flags.Jokes.Refresh.Duration, "jokes-refresh", flags.Jokes.Refresh.Duration, "How often the full jokes corpus is downloaded and refreshed in memory.")
+	cmd.PersistentFlags().IntVar(&flags.Jokes.Breaker.Threshold, "jokes-breaker-threshold", flags.Jokes.Breaker.Threshold, "Number of consecutive failed jokes API requests which open its circuit breaker.")
+	cmd.PersistentFlags().DurationVar(&flags.Jokes.Breaker.Cooldown.Duration, "jokes-breaker-cooldown", flags.Jokes.Breaker.Cooldown.Duration, "How long an open jokes API circuit breaker fails requests at once before allowing a trial request.")
 
 	if err := cmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -50,3 +82,48 @@ func Init(version string) {
 		os.Exit(0)
 	}
 }
+
+// Apply overrides cfg with every flag explicitly set on the command line.  Flags take precedence over all
+// other configuration sources.
+func Apply(cfg *config.Config) {
+	overrides := map[string]func(){
+		"port":                    func() { cfg.Port = flags.Port },
+		"metrics-port":            func() { cfg.MetricsPort = flags.MetricsPort },
+		"admin-port":              func() { cfg.AdminPort = flags.AdminPort },
+		"shutdown-grace":          func() { cfg.ShutdownGrace = flags.ShutdownGrace },
+		"trust-user-name-header":  func() { cfg.TrustUserNameHeader = flags.TrustUserNameHeader },
+		"log-level":               func() { cfg.Log.Level = flags.Log.Level },
+		"log-format":              func() { cfg.Log.Format = flags.Log.Format },
+		"pretty-json":             func() { cfg.Log.PrettyJson = flags.Log.PrettyJson },
+		"access-log":              func() { cfg.Log.Access = flags.Log.Access },
+		"log-revert-after":        func() { cfg.Log.RevertAfter = flags.Log.RevertAfter },
+		"names-url":               func() { cfg.Names.Url = flags.Names.Url },
+		"names-chan-size":         func() { cfg.Names.ChanSize = flags.Names.ChanSize },
+		"names-ready-threshold":   func() { cfg.Names.ReadyThreshold = flags.Names.ReadyThreshold },
+		"names-budget":            func() { cfg.Names.Budget = flags.Names.Budget },
+		"names-budget-window":     func() { cfg.Names.BudgetWindow = flags.Names.BudgetWindow },
+		"names-budget-kind":       func() { cfg.Names.BudgetKind = flags.Names.BudgetKind },
+		"names-breaker-threshold": func() { cfg.Names.Breaker.Threshold = flags.Names.Breaker.Threshold },
+		"names-breaker-cooldown":  func() { cfg.Names.Breaker.Cooldown = flags.Names.Breaker.Cooldown },
+		"name-cache-size":         func() { cfg.Names.Cache.Size = flags.Names.Cache.Size },
+		"name-cache-ttl":          func() { cfg.Names.Cache.TTL = flags.Names.Cache.TTL },
+		"names-snapshot":          func() { cfg.Names.Snapshot = flags.Names.Snapshot },
+		"names-snapshot-interval": func() { cfg.Names.SnapshotInterval = flags.Names.SnapshotInterval },
+		"client-pin-size":         func() { cfg.ClientPins.Size = flags.ClientPins.Size },
+		"client-pin-ttl":          func() { cfg.ClientPins.TTL = flags.ClientPins.TTL },
+		"jokes-url":               func() { cfg.Jokes.Url = flags.Jokes.Url },
+		"jokes-corpus-url":        func() { cfg.Jokes.CorpusUrl = flags.Jokes.CorpusUrl },
+		"jokes-categories-url":    func() { cfg.Jokes.CategoriesUrl = flags.Jokes.CategoriesUrl },
+		"jokes-categories":        func() { cfg.Jokes.Categories = flags.Jokes.Categories },
+		"jokes-max-batch":         func() { cfg.Jokes.MaxBatch = flags.Jokes.MaxBatch },
+		"jokes-refresh":           func() { cfg.Jokes.Refresh = flags.Jokes.Refresh },
+		"jokes-breaker-threshold": func() { cfg.Jokes.Breaker.Threshold = flags.Jokes.Breaker.Threshold },
+		"jokes-breaker-cooldown":  func() { cfg.Jokes.Breaker.Cooldown = flags.Jokes.Breaker.Cooldown },
+	}
+	for name, override := range overrides {
+		if cmd.PersistentFlags().Changed(name) {
+			override()
+		}
+	}
+	cfg.Normalize()
+}
@@ -0,0 +1,122 @@
+package jokesontap
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/swtch1/jokesontap/breaker"
+	"github.com/swtch1/jokesontap/retry"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HttpClientOpts configures a http client used to request an upstream API.
+type HttpClientOpts struct {
+	// Timeout is the time limit for an entire request, including reading the response body.
+	Timeout            time.Duration
+	MaxIdleConns       int
+	IdleConnTimeout    time.Duration
+	DisableCompression bool
+}
+
+// DefaultHttpClientOpts are the options used by upstream API clients unless otherwise configured.
+var DefaultHttpClientOpts = HttpClientOpts{
+	Timeout:            5 * time.Second,
+	MaxIdleConns:       10,
+	IdleConnTimeout:    30 * time.Second,
+	DisableCompression: true,
+}
+
+// DefaultRetryPolicy is how upstream API clients retry failed requests unless otherwise configured.
+var DefaultRetryPolicy = retry.Policy{
+	Retries:   2,
+	BaseDelay: 100 * time.Millisecond,
+	MaxDelay:  time.Second,
+	Budget:    2 * time.Second,
+}
+
+// NewHttpClient creates a http client which can be reused across multiple requests.
+func NewHttpClient(opts HttpClientOpts) *http.Client {
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			DisableCompression: opts.DisableCompression,
+			MaxIdleConns:       opts.MaxIdleConns,
+			IdleConnTimeout:    opts.IdleConnTimeout,
+		},
+	}
+}
+
+// upstreamResult gets what err, the result of a request to an upstream API, shows about the API for its circuit
+// breaker.  Failures which are the fault of the caller, like asking for a joke which doesn't exist, show the API is
+// working even though the request didn't succeed.  A request we gave up on shows nothing either way, so it must
+// neither close nor open the breaker.
+func upstreamResult(err error) breaker.Result {
+	if canceled(err) {
+		return breaker.Ignored
+	}
+	switch errors.Cause(err) {
+	case nil, ErrJokeNotFound, ErrNamesApiTooManyRequests, ErrJokesApiTooManyRequests:
+		return breaker.Success
+	}
+	return breaker.Failure
+}
+
+// canceled returns true if err, or the cause of err, is context.Canceled, including when a http client's request
+// was abandoned because its context was cancelled.
+func canceled(err error) bool {
+	cause := errors.Cause(err)
+	if urlErr, ok := cause.(*url.Error); ok {
+		cause = urlErr.Err
+	}
+	return cause == context.Canceled
+}
+
+// retryAfterError is an error from an upstream API which said how long to wait before trying again.  The cause of a
+// retryAfterError is the error it describes, so it can still be compared with errors.Cause.
+type retryAfterError struct {
+	cause error
+	after time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.cause, e.after)
+}
+
+func (e *retryAfterError) Cause() error {
+	return e.cause
+}
+
+// withRetryAfter adds how long resp asked us to wait before trying again to err, if it asked.
+func withRetryAfter(err error, resp *http.Response) error {
+	after, ok := retry.After(resp)
+	if !ok {
+		return err
+	}
+	return &retryAfterError{cause: err, after: after}
+}
+
+// upstreamRetryAfter gets how long an upstream API asked us to wait before trying again, if err, or any error it
+// wraps, says.
+func upstreamRetryAfter(err error) (time.Duration, bool) {
+	for err != nil {
+		if ra, ok := err.(*retryAfterError); ok {
+			return ra.after, true
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return 0, false
+}
+
+// forwardRequestID sends the ID of the request being handled with req's context on to the upstream API, so the request
+// can be followed across services.
+func forwardRequestID(req *http.Request) {
+	if id := RequestID(req.Context()); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	log "github.com/sirupsen/logrus"
 	"github.com/swtch1/jokesontap"
+	"github.com/swtch1/jokesontap/breaker"
+	"github.com/swtch1/jokesontap/budget"
 	"github.com/swtch1/jokesontap/cli"
+	"github.com/swtch1/jokesontap/config"
+	"github.com/swtch1/jokesontap/metric"
+	"github.com/swtch1/jokesontap/retry"
+	"math/rand"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
@@ -12,67 +19,312 @@ import (
 	"time"
 )
 
-var (
-	// buildVersion is the application version and should be populated at build time by build ldflags
-	// this default message should be overwritten
-	buildVersion = "unset: please file an issue"
-
-	// defaultNamesUrl is the default API URL used to get new random names
-	defaultNamesUrl = "https://uinames.com/api/?amount=500"
-	// defaultJokesUrl is the default API URL used to get new random jokes
-	defaultJokesUrl = "http://api.icndb.com/jokes/random"
-	// defaultNameChanSize is the default size of the channel used to store names
-	// so that names can be eagerly retrieved from the API
-	defaultNameChanSize = 100000
-)
+// buildVersion is the application version and should be populated at build time by build ldflags
+// this default message should be overwritten
+var buildVersion = "unset: please file an issue"
 
 func main() {
-	cli.Init(buildVersion)
-	jokesontap.InitLogger(os.Stderr, cli.LogLevel, cli.LogFormat, cli.PrettyPrintJsonLogs)
-
-	namesUrl, err := url.Parse(defaultNamesUrl)
+	started := time.Now()
+	cli.Init(buildVersion, config.Default())
+	cfg, err := config.Load(cli.ConfigFile, os.LookupEnv)
 	if err != nil {
-		log.WithError(err).Fatal("unable to parse default names URL, please submit an issue")
+		log.WithError(err).Fatal("unable to load configuration")
+	}
+	cli.Apply(&cfg)
+	if err := cfg.Validate(); err != nil {
+		log.WithError(err).Fatal("unable to start server")
+	}
+
+	jokesontap.InitLogger(os.Stderr, cfg.Log.Level, cfg.Log.Format, cfg.Log.PrettyJson)
+	logSwitch := jokesontap.NewLogSwitch(cfg.Log.Level, cfg.Log.Format, cfg.Log.PrettyJson)
+	logSwitch.RevertAfter = cfg.Log.RevertAfter.Duration
+	log.Infof("effective configuration: %s", cfg)
+	rand.Seed(time.Now().UnixNano())
+
+	// ctx is cancelled when the server is stopping, stopping all background work with it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go HandleLogSignals(ctx, logSwitch)
+
+	if cfg.MetricsPort != 0 {
+		metric.Prometheus{Port: cfg.MetricsPort}.Run()
 	}
+
+	namesUrl := mustParseUrl(cfg.Names.Url)
 	nameClient := jokesontap.NewNameClient(*namesUrl)
-	namesChan := make(chan jokesontap.Name, defaultNameChanSize)
+	nameClient.HttpClient = jokesontap.NewHttpClient(httpClientOpts(cfg.Names.Client))
+	nameClient.Retry = retryPolicy(cfg.Names.Client)
+	nameClient.Breaker = newBreaker("names", cfg.Names.Breaker)
+	// names got from the names API are cached along with the names served, so names can be reused as soon as the
+	// first batch arrives rather than only once names have been served
+	nameCache := jokesontap.NewLRUCache(cfg.Names.Cache.Size, cfg.Names.Cache.TTL.Duration)
+	nameClient.Cache = nameCache
+	namesChan := make(chan jokesontap.Name, cfg.Names.ChanSize)
+	metric.ObserveNamesChan(len(namesChan), cap(namesChan))
 
-	// NOTE: the size of the budget array has been shortened to 6 rather than the API specified 7 requests per minute as
-	// real world testing showed that rate limit errors were still being seen at 7 requests per every 65 seconds.
-	// TODO: re-evaluate the names API at regular intervals to determine the optimal request rate
+	// names left over from the last run are restored before any new names are requested or served
+	var snapshot *jokesontap.NameSnapshot
+	var pending *jokesontap.PendingNames
+	// savingDone is closed once periodic snapshots have stopped, so they can't overlap the final snapshot
+	savingDone := make(chan struct{})
+	if cfg.Names.Snapshot != "" {
+		snapshot = jokesontap.NewNameSnapshot(cfg.Names.Snapshot, namesChan)
+		snapshot.Interval = cfg.Names.SnapshotInterval.Duration
+		pending = snapshot.Pending
+		n, err := snapshot.Load()
+		if err != nil {
+			log.WithError(err).WithField("path", snapshot.Path).Warn("unable to restore names snapshot, starting without saved names")
+		} else {
+			log.WithField("names", n).Info("restored names snapshot")
+		}
+		go func() {
+			defer close(savingDone)
+			snapshot.SaveOften(ctx)
+		}()
+	}
+
+	providers := []*jokesontap.NameProvider{{
+		Name:      "default",
+		Requester: nameClient,
+		Budget:    newBudget(cfg.Names.BudgetKind, cfg.Names.Budget, cfg.Names.BudgetWindow.Duration),
+		Weight:    1,
+	}}
+	for _, p := range cfg.Names.Providers {
+		providers = append(providers, nameProvider(p, cfg.Names.Client, cfg.Names.Breaker))
+	}
+	// every provider has its own budget, so the providers are requested without an overall budget
+	nameProviders := jokesontap.NewNameProviders(providers...)
 	budgetReq := jokesontap.BudgetNameReq{
-		// allow small buffer to avoid getting rate limited from names API
-		// ref: http://www.icndb.com/api/
-		MinDiff:    time.Second * 61,
-		NameClient: nameClient,
+		NameClient: nameProviders,
 		NameChan:   namesChan,
+		Pending:    pending,
 	}
-	go budgetReq.RequestOften()
+	// requestingDone is closed once no more names will be pushed, so the final snapshot holds every unconsumed name
+	requestingDone := make(chan struct{})
+	go func() {
+		defer close(requestingDone)
+		budgetReq.RequestOften(ctx)
+	}()
 
-	jokesUrl, err := url.Parse(defaultJokesUrl)
-	if err != nil {
-		log.WithError(err).Fatalf("unable to parse jokes URL '%s', please file an issue", defaultJokesUrl)
-	}
+	jokesUrl := mustParseUrl(cfg.Jokes.Url)
 	jokeClient := jokesontap.NewJokeClient(*jokesUrl)
+	jokeClient.HttpClient = jokesontap.NewHttpClient(httpClientOpts(cfg.Jokes.Client))
+	jokeClient.Retry = retryPolicy(cfg.Jokes.Client)
+	jokeClient.Breaker = newBreaker("jokes", cfg.Jokes.Breaker)
+	jokeClient.CategoriesUrl = *mustParseUrl(cfg.Jokes.CategoriesUrl)
+	jokeClient.JokesUrl = *mustParseUrl(cfg.Jokes.CorpusUrl)
 
-	go HandleInterrupt()
-	log.Infof("starting server on port %d", cli.Port)
+	corpusUrl := mustParseUrl(cfg.Jokes.CorpusUrl)
+	jokeStore := jokesontap.NewJokeStore(*corpusUrl)
+	jokeStore.RefreshInterval = cfg.Jokes.Refresh.Duration
+	// the server falls back to querying the jokes API directly until the store is populated
+	if err := jokeStore.Refresh(ctx); err != nil {
+		log.WithError(err).Error("unable to load jokes corpus, jokes will be requested from the jokes API")
+	}
+	go jokeStore.RefreshOften(ctx)
+
+	interrupt := HandleInterrupt()
+	readiness := jokesontap.NewReadiness()
+	readiness.Register("names_pool", jokesontap.NamesPoolCheck(namesChan, cfg.Names.ReadyThreshold))
+	readiness.Register("names_providers", jokesontap.NameProvidersCheck(nameProviders))
+	readiness.Register("jokes_breaker", jokesontap.BreakerCheck(jokeClient.Breaker))
+	readiness.Register("jokes_api", jokesontap.ReachableCheck(*jokesUrl))
+
+	var accessLog *jokesontap.AccessLogger
+	if cfg.Log.Access != "off" {
+		if accessLog, err = jokesontap.NewAccessLogger(os.Stdout, cfg.Log.Access); err != nil {
+			log.WithError(err).Fatal("unable to create access log")
+		}
+	}
+
+	log.Infof("starting server on port %d", cfg.Port)
 	srv := &jokesontap.Server{
-		Port:       cli.Port,
-		Names:      namesChan,
-		JokeClient: jokeClient,
+		Port:                cfg.Port,
+		Names:               namesChan,
+		NameTaken:           budgetReq.Wake,
+		Pending:             pending,
+		JokeClient:          jokeClient,
+		JokeStore:           jokeStore,
+		NameCache:           nameCache,
+		Pins:                jokesontap.NewLRUCache(cfg.ClientPins.Size, cfg.ClientPins.TTL.Duration),
+		TrustUserNameHeader: cfg.TrustUserNameHeader,
+		Categories:          cfg.Jokes.Categories,
+		MaxJokes:            cfg.Jokes.MaxBatch,
+		AccessLog:           accessLog,
+		Readiness:           readiness,
 	}
-	log.Fatal(srv.ListenAndServe())
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- srv.ListenAndServe()
+	}()
+
+	var admin *jokesontap.Admin
+	if cfg.AdminPort != 0 {
+		breakers := []*breaker.Breaker{jokeClient.Breaker}
+		for _, p := range providers {
+			if c, ok := p.Requester.(*jokesontap.NameClient); ok {
+				breakers = append(breakers, c.Breaker)
+			}
+		}
+		admin = jokesontap.NewAdmin(cfg.AdminPort)
+		admin.Token = cfg.AdminToken
+		admin.Handle("/debug/status", &jokesontap.Status{
+			Version:  buildVersion,
+			Started:  started,
+			Names:    &budgetReq,
+			Jokes:    jokeStore,
+			Breakers: breakers,
+		})
+		if cfg.AdminToken != "" {
+			admin.Handle("/debug/log", logSwitch)
+		} else {
+			log.Info("no admin token is set, log settings can only be changed with SIGUSR1 and SIGUSR2")
+		}
+		log.Infof("starting admin server on port %d", cfg.AdminPort)
+		go func() {
+			if err := admin.ListenAndServe(); err != http.ErrServerClosed {
+				log.WithError(err).Error("admin server stopped")
+			}
+		}()
+	}
+
+	select {
+	case err := <-srvErr:
+		log.WithError(err).Fatal("server stopped unexpectedly")
+	case sig := <-interrupt:
+		log.WithField("signal", sig.String()).Infof("interrupt: draining server for up to %s", cfg.ShutdownGrace)
+	}
+
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownGrace.Duration)
+	defer shutdownCancel()
+	drainErr := srv.Shutdown(shutdownCtx)
+	if admin != nil {
+		admin.Shutdown(shutdownCtx)
+	}
+	if snapshot != nil {
+		select {
+		case <-requestingDone:
+		case <-shutdownCtx.Done():
+			log.Warn("names are still being requested, saving names snapshot anyway")
+		}
+		<-savingDone
+		n, err := snapshot.Save()
+		if err != nil {
+			log.WithError(err).WithField("path", snapshot.Path).Error("unable to save names snapshot")
+		} else {
+			log.WithField("names", n).Info("saved names snapshot")
+		}
+	}
+	if drainErr != nil {
+		log.WithError(drainErr).Error("unable to drain server within the grace period")
+		os.Exit(1)
+	}
+	log.Info("server stopped")
 }
 
-// HandleInterrupt will immediately terminate the server if it detects an interrupt signal.
-func HandleInterrupt() {
+// HandleInterrupt returns a channel which receives the first interrupt or terminate signal sent to the server.
+func HandleInterrupt() <-chan os.Signal {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	return sigs
+}
 
-	go func() {
-		<-sigs
-		fmt.Println("interrupt: stopping server...")
-		os.Exit(1)
-	}()
+// HandleLogSignals toggles the log level between trace and the configured level on SIGUSR1, and the log format
+// between text and json on SIGUSR2, until ctx is done.
+func HandleLogSignals(ctx context.Context, l *jokesontap.LogSwitch) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigs:
+			switch sig {
+			case syscall.SIGUSR1:
+				l.ToggleLevel()
+			case syscall.SIGUSR2:
+				l.ToggleFormat()
+			}
+		}
+	}
+}
+
+// mustParseUrl parses a URL which has already been validated.
+func mustParseUrl(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		log.WithError(err).Fatalf("unable to parse URL '%s', please file an issue", raw)
+	}
+	return u
+}
+
+// nameProvider creates the name provider described by p, where http providers use a client configured by c and a
+// circuit breaker configured by b.
+func nameProvider(p config.NameProvider, c config.HttpClient, b config.Breaker) *jokesontap.NameProvider {
+	provider := &jokesontap.NameProvider{
+		Name:     p.Name,
+		Budget:   newBudget(p.BudgetKind, p.Budget, p.BudgetWindow.Duration),
+		Priority: p.Priority,
+		Weight:   p.Weight,
+	}
+
+	if p.Generator() {
+		seed := p.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		gen, err := jokesontap.NewNameGenerator(p.Locale, p.Gender, seed)
+		if err != nil {
+			log.WithError(err).WithField("provider", p.Name).Fatal("unable to create name generator")
+		}
+		provider.Requester = gen
+		return provider
+	}
+
+	client := jokesontap.NewNameClient(*mustParseUrl(p.Url))
+	client.HttpClient = jokesontap.NewHttpClient(httpClientOpts(c))
+	client.Retry = retryPolicy(c)
+	client.Breaker = newBreaker("names:"+p.Name, b)
+	provider.Requester = client
+	return provider
+}
+
+// newBudget creates a budget of the given kind allowing ops operations per window.
+func newBudget(kind string, ops int, window time.Duration) budget.Budget {
+	switch kind {
+	case "token":
+		return budget.NewTokenBucket(ops, window/time.Duration(ops))
+	default:
+		return budget.NewSlidingWindow(ops, window)
+	}
+}
+
+// newBreaker creates a circuit breaker configured by b whose state is recorded in metrics.
+func newBreaker(name string, b config.Breaker) *breaker.Breaker {
+	cb := breaker.New(name, b.Threshold, b.Cooldown.Duration)
+	cb.OnStateChange = func(name string, from, to breaker.State) {
+		metric.ObserveBreaker(name, int(to))
+	}
+	metric.ObserveBreaker(name, int(breaker.Closed))
+	return cb
+}
+
+func httpClientOpts(c config.HttpClient) jokesontap.HttpClientOpts {
+	return jokesontap.HttpClientOpts{
+		Timeout:            c.Timeout.Duration,
+		MaxIdleConns:       c.MaxIdleConns,
+		IdleConnTimeout:    c.IdleConnTimeout.Duration,
+		DisableCompression: c.DisableCompression,
+	}
+}
+
+func retryPolicy(c config.HttpClient) retry.Policy {
+	return retry.Policy{
+		Retries:   c.Retry.Retries,
+		BaseDelay: c.Retry.BaseDelay.Duration,
+		MaxDelay:  c.Retry.MaxDelay.Duration,
+		Budget:    c.Retry.Budget.Duration,
+	}
 }
@@ -0,0 +1,454 @@
+// Package config loads the server configuration, layering a config file and environment variables over defaults.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of every environment variable which configures the server.
+const EnvPrefix = "JOKESONTAP_"
+
+// Config is the full server configuration.
+type Config struct {
+	// Port is the port which the server will listen on.
+	Port int32 `json:"port"`
+	// MetricsPort is the port where Prometheus metrics are served, or 0 to disable metrics.
+	MetricsPort int `json:"metricsPort"`
+	// AdminPort is the port where admin endpoints like /debug/status are served, or 0 to disable them.
+	AdminPort int `json:"adminPort"`
+	// AdminToken must be given as a bearer token on admin requests.  Admin endpoints which change the server, like
+	// /debug/log, are only served when it is set.
+	AdminToken string `json:"adminToken"`
+	// ShutdownGrace is how long in-flight requests are given to complete when the server is stopping.
+	ShutdownGrace Duration `json:"shutdownGrace"`
+	// TrustUserNameHeader serves jokes about the user named in the X-User-Name header.  Only enable this behind a
+	// proxy which sets the header itself.
+	TrustUserNameHeader bool  `json:"trustUserNameHeader"`
+	Log                 Log   `json:"log"`
+	Names               Names `json:"names"`
+	Jokes               Jokes `json:"jokes"`
+	ClientPins          Cache `json:"clientPins"`
+}
+
+// Log configures application logging.
+type Log struct {
+	// Level should be one of trace, debug, info, warn, error, fatal.
+	Level string `json:"level"`
+	// Format should be one of text, json.
+	Format     string `json:"format"`
+	PrettyJson bool   `json:"prettyJson"`
+	// Access is the format of the access log written to stdout, one of common, combined, json or off.
+	Access string `json:"access"`
+	// RevertAfter is how long a level or format changed at runtime lasts before reverting to the configured one, or
+	// 0 for changes to last until reverted.
+	RevertAfter Duration `json:"revertAfter"`
+}
+
+// Names configures how random names are requested and stored.
+type Names struct {
+	// Url is the full URL of the names API.
+	Url string `json:"url"`
+	// ChanSize is the number of names that can be eagerly retrieved and held ready for requests.
+	ChanSize int `json:"chanSize"`
+	// ReadyThreshold is the number of names which must be held ready before the server first reports that it is ready.
+	ReadyThreshold int `json:"readyThreshold"`
+	// Budget is the maximum number of names API requests allowed within BudgetWindow.
+	Budget       int      `json:"budget"`
+	BudgetWindow Duration `json:"budgetWindow"`
+	// BudgetKind should be one of sliding, token.
+	BudgetKind string     `json:"budgetKind"`
+	Client     HttpClient `json:"client"`
+	// Breaker stops requesting a names API which keeps failing.  Every http provider has its own breaker.
+	Breaker Breaker `json:"breaker"`
+	// Cache holds served names for reuse when no fresh names are available.
+	Cache Cache `json:"cache"`
+	// Snapshot is the file where unconsumed names are kept across restarts, or empty to disable snapshots.
+	Snapshot         string   `json:"snapshot"`
+	SnapshotInterval Duration `json:"snapshotInterval"`
+	// Providers are additional sources of names.  The names API at Url is always the provider named "default" with
+	// priority 0 and weight 1, providers with a higher priority number are tried when it fails or is rate limited.
+	Providers []NameProvider `json:"providers"`
+}
+
+// NameProvider configures an additional source of names with its own budget.
+type NameProvider struct {
+	Name string `json:"name"`
+	// Kind should be one of http, which requests names from a names API at Url, or generator, which generates
+	// names offline.  Defaults to http.
+	Kind string `json:"kind"`
+	Url  string `json:"url"`
+	// Budget is the maximum number of requests allowed within BudgetWindow.  Generators need a budget too, so that
+	// they don't fill the names channel with generated names in a moment.
+	Budget       int      `json:"budget"`
+	BudgetWindow Duration `json:"budgetWindow"`
+	// BudgetKind should be one of sliding, token.  Defaults to sliding.
+	BudgetKind string `json:"budgetKind"`
+	// Priority orders providers, those with a lower priority are always tried first.
+	Priority int `json:"priority"`
+	// Weight is how often the provider is tried first relative to other providers with the same priority.
+	Weight int `json:"weight"`
+	// Locale limits generated names to a single locale, like en_US.  Names from every locale are generated when empty.
+	Locale string `json:"locale"`
+	// Gender should be one of male, female, or empty to generate names of either gender.
+	Gender string `json:"gender"`
+	// Seed makes generated names repeatable.  A random seed is used when 0.
+	Seed int64 `json:"seed"`
+}
+
+// Generator returns true if the provider generates names offline.
+func (p NameProvider) Generator() bool {
+	return strings.ToLower(p.Kind) == "generator"
+}
+
+// Jokes configures how jokes are requested and stored.
+type Jokes struct {
+	// Url is the URL of the jokes API which returns a single random joke.
+	Url string `json:"url"`
+	// CorpusUrl is the URL of the jokes API which returns every joke, and under which each joke is found by its ID.
+	CorpusUrl string `json:"corpusUrl"`
+	// CategoriesUrl is the URL of the jokes API which lists every joke category.
+	CategoriesUrl string `json:"categoriesUrl"`
+	// Refresh is how often the full jokes corpus is downloaded.
+	Refresh Duration `json:"refresh"`
+	// Categories are the joke categories served when a request doesn't ask for any, or every category when empty.
+	Categories []string `json:"categories"`
+	// MaxBatch is the most jokes served by a single request to /jokes.
+	MaxBatch int        `json:"maxBatch"`
+	Client   HttpClient `json:"client"`
+	// Breaker stops requesting the jokes API while it keeps failing.
+	Breaker Breaker `json:"breaker"`
+}
+
+// HttpClient configures a http client used to request an upstream API.
+type HttpClient struct {
+	Timeout            Duration `json:"timeout"`
+	MaxIdleConns       int      `json:"maxIdleConns"`
+	IdleConnTimeout    Duration `json:"idleConnTimeout"`
+	DisableCompression bool     `json:"disableCompression"`
+	Retry              Retry    `json:"retry"`
+}
+
+// Retry configures how failed requests to an upstream API are retried, with exponential backoff and jitter.
+type Retry struct {
+	// Retries is the most times a single request is retried.  Requests are not retried when 0.
+	Retries int `json:"retries"`
+	// BaseDelay is the longest wait before the first retry, doubling for each retry after up to MaxDelay.
+	BaseDelay Duration `json:"baseDelay"`
+	MaxDelay  Duration `json:"maxDelay"`
+	// Budget is the most time a single request may spend waiting to be retried, including any wait asked for by
+	// the upstream API with Retry-After.  There is no limit when 0.
+	Budget Duration `json:"budget"`
+}
+
+// Breaker configures a circuit breaker around an upstream API.
+type Breaker struct {
+	// Threshold is the number of consecutive failed requests which open the breaker.
+	Threshold int `json:"threshold"`
+	// Cooldown is how long an open breaker fails requests at once before allowing a trial request.
+	Cooldown Duration `json:"cooldown"`
+}
+
+// Cache configures a bounded cache.
+type Cache struct {
+	Size int      `json:"size"`
+	TTL  Duration `json:"ttl"`
+}
+
+// Default is the configuration used for any value not otherwise set.
+func Default() Config {
+	client := HttpClient{
+		Timeout:            Duration{5 * time.Second},
+		MaxIdleConns:       10,
+		IdleConnTimeout:    Duration{30 * time.Second},
+		DisableCompression: true,
+		Retry: Retry{
+			Retries:   2,
+			BaseDelay: Duration{100 * time.Millisecond},
+			MaxDelay:  Duration{time.Second},
+			Budget:    Duration{2 * time.Second},
+		},
+	}
+	breaker := Breaker{Threshold: 5, Cooldown: Duration{30 * time.Second}}
+	return Config{
+		Port:          5000,
+		MetricsPort:   9090,
+		AdminPort:     9091,
+		ShutdownGrace: Duration{10 * time.Second},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+			Access: "common",
+		},
+		Names: Names{
+			Url:            "https://uinames.com/api/?amount=500",
+			ChanSize:       100000,
+			ReadyThreshold: 100,
+			// NOTE: the default budget has been shortened to 6 rather than the API specified 7 requests per minute as
+			// real world testing showed that rate limit errors were still being seen at 7 requests per every 65 seconds.
+			// TODO: re-evaluate the names API at regular intervals to determine the optimal request rate
+			Budget: 6,
+			// allow small buffer to avoid getting rate limited from names API
+			BudgetWindow: Duration{61 * time.Second},
+			BudgetKind:   "sliding",
+			Client:       client,
+			Breaker:      breaker,
+			Cache:        Cache{Size: 10000, TTL: Duration{time.Hour}},
+			// names are worth keeping across restarts but not across reboots
+			Snapshot:         filepath.Join(os.TempDir(), "jokesontap-names.json"),
+			SnapshotInterval: Duration{5 * time.Minute},
+		},
+		Jokes: Jokes{
+			Url:           "http://api.icndb.com/jokes/random",
+			CorpusUrl:     "http://api.icndb.com/jokes",
+			CategoriesUrl: "http://api.icndb.com/categories",
+			Refresh:       Duration{24 * time.Hour},
+			Categories:    []string{"nerdy"},
+			MaxBatch:      50,
+			Client:        client,
+			Breaker:       breaker,
+		},
+		ClientPins: Cache{Size: 10000, TTL: Duration{24 * time.Hour}},
+	}
+}
+
+// Load gets the default configuration, overridden by the config file at path, if any, and then by environment
+// variables found with lookupEnv.
+func Load(path string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.applyEnv(lookupEnv); err != nil {
+		return cfg, err
+	}
+	cfg.Normalize()
+	return cfg, nil
+}
+
+// Normalize lower cases the values of fields which are one of a set of values, like the log level, so they can be
+// compared exactly wherever they're used.  Normalize should be called again after the configuration is changed.
+func (c *Config) Normalize() {
+	lower := []*string{&c.Log.Level, &c.Log.Format, &c.Log.Access, &c.Names.BudgetKind}
+	for i := range c.Names.Providers {
+		p := &c.Names.Providers[i]
+		lower = append(lower, &p.Kind, &p.BudgetKind, &p.Gender)
+	}
+	for _, s := range lower {
+		*s = strings.ToLower(strings.TrimSpace(*s))
+	}
+}
+
+// loadFile overrides the configuration with any values set in the file at path.
+func (c *Config) loadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read config file '%s'", path)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".yaml", ".yml":
+		if b, err = yamlToJson(b); err != nil {
+			return errors.Wrapf(err, "unable to parse config file '%s'", path)
+		}
+	default:
+		return fmt.Errorf("unsupported config file format '%s', config files must be JSON or YAML", filepath.Ext(path))
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return errors.Wrapf(err, "unable to parse config file '%s'", path)
+	}
+	return nil
+}
+
+// yamlToJson converts a YAML document to JSON, so YAML config files are read with the same field names and
+// decoding as JSON ones.
+func yamlToJson(b []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonValue(v))
+}
+
+// jsonValue replaces the maps with keys of any type which YAML decodes to with maps keyed by string, which JSON
+// requires.
+func jsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range value {
+			value[i] = jsonValue(item)
+		}
+		return value
+	default:
+		return value
+	}
+}
+
+// Validate returns an error describing the first invalid value found in the configuration.
+func (c Config) Validate() error {
+	checks := []struct {
+		ok  bool
+		msg string
+	}{
+		{c.Port > 0 && c.Port < 65536, "port must be between 1 and 65535"},
+		{c.MetricsPort >= 0 && c.MetricsPort < 65536, "metrics port must be between 0 and 65535"},
+		{c.MetricsPort != int(c.Port), "metrics port must differ from the server port"},
+		{c.AdminPort >= 0 && c.AdminPort < 65536, "admin port must be between 0 and 65535"},
+		{c.AdminPort == 0 || (c.AdminPort != int(c.Port) && c.AdminPort != c.MetricsPort), "admin port must differ from the server and metrics ports"},
+		{c.ShutdownGrace.Duration >= 0, "shutdown grace must not be negative"},
+		{oneOf(c.Log.Level, "trace", "debug", "info", "warn", "error", "fatal"), "log level should be one of trace, debug, info, warn, error, fatal"},
+		{oneOf(c.Log.Format, "text", "json"), "log format should be one of text, json"},
+		{oneOf(c.Log.Access, "common", "combined", "json", "off"), "access log format should be one of common, combined, json, off"},
+		{c.Log.RevertAfter.Duration >= 0, "log revert after must not be negative"},
+		{validUrl(c.Names.Url), "names URL must be an absolute http or https URL"},
+		{c.Names.ChanSize > 0, "names channel size must be at least 1"},
+		{c.Names.ReadyThreshold >= 0 && c.Names.ReadyThreshold <= c.Names.ChanSize, "names ready threshold must be between 0 and the names channel size"},
+		{c.Names.Budget > 0, "names budget must allow at least one request"},
+		{c.Names.BudgetWindow.Duration > 0, "names budget window must be positive"},
+		{oneOf(c.Names.BudgetKind, "sliding", "token"), "names budget kind should be one of sliding, token"},
+		{c.Names.Client.valid(), "names client timeouts, idle connections and retries must not be negative"},
+		{c.Names.Breaker.valid(), "names breaker threshold must be at least 1 and cooldown must be positive"},
+		{c.Names.Cache.Size > 0, "name cache size must be at least 1"},
+		{c.Names.Cache.TTL.Duration >= 0, "name cache TTL must not be negative"},
+		{c.Names.SnapshotInterval.Duration > 0, "names snapshot interval must be positive"},
+		{validUrl(c.Jokes.Url), "jokes URL must be an absolute http or https URL"},
+		{validUrl(c.Jokes.CorpusUrl), "jokes corpus URL must be an absolute http or https URL"},
+		{validUrl(c.Jokes.CategoriesUrl), "jokes categories URL must be an absolute http or https URL"},
+		{!oneOf("", c.Jokes.Categories...), "jokes categories must not be empty strings"},
+		{c.Jokes.MaxBatch > 0, "jokes max batch must be at least 1"},
+		{c.Jokes.Refresh.Duration > 0, "jokes refresh must be positive"},
+		{c.Jokes.Client.valid(), "jokes client timeouts, idle connections and retries must not be negative"},
+		{c.Jokes.Breaker.valid(), "jokes breaker threshold must be at least 1 and cooldown must be positive"},
+		{c.ClientPins.Size > 0, "client pin size must be at least 1"},
+		{c.ClientPins.TTL.Duration >= 0, "client pin TTL must not be negative"},
+	}
+	for _, check := range checks {
+		if !check.ok {
+			return errors.New("invalid configuration: " + check.msg)
+		}
+	}
+
+	seen := map[string]bool{"default": true}
+	for _, p := range c.Names.Providers {
+		if seen[p.Name] {
+			return fmt.Errorf("invalid configuration: name provider '%s' must have a unique name other than default", p.Name)
+		}
+		seen[p.Name] = true
+		if err := p.validate(); err != nil {
+			return errors.Wrapf(err, "invalid configuration: name provider '%s'", p.Name)
+		}
+	}
+	return nil
+}
+
+func (p NameProvider) validate() error {
+	checks := []struct {
+		ok  bool
+		msg string
+	}{
+		{p.Name != "", "name must not be empty"},
+		{p.Kind == "" || oneOf(p.Kind, "http", "generator"), "kind should be one of http, generator"},
+		{p.Generator() || validUrl(p.Url), "URL must be an absolute http or https URL"},
+		{p.Budget > 0, "budget must allow at least one request"},
+		{p.BudgetWindow.Duration > 0, "budget window must be positive"},
+		{p.BudgetKind == "" || oneOf(p.BudgetKind, "sliding", "token"), "budget kind should be one of sliding, token"},
+		{p.Weight >= 0, "weight must not be negative"},
+		{p.Gender == "" || oneOf(p.Gender, "male", "female"), "gender should be one of male, female"},
+	}
+	for _, check := range checks {
+		if !check.ok {
+			return errors.New(check.msg)
+		}
+	}
+	return nil
+}
+
+// String is the configuration as JSON, suitable for logging.  Secrets are redacted.
+func (c Config) String() string {
+	if c.AdminToken != "" {
+		c.AdminToken = "redacted"
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("unable to marshal configuration: %s", err)
+	}
+	return string(b)
+}
+
+func (c HttpClient) valid() bool {
+	return c.Timeout.Duration >= 0 && c.MaxIdleConns >= 0 && c.IdleConnTimeout.Duration >= 0 && c.Retry.valid()
+}
+
+func (r Retry) valid() bool {
+	return r.Retries >= 0 && r.BaseDelay.Duration >= 0 && r.MaxDelay.Duration >= 0 && r.Budget.Duration >= 0
+}
+
+func (b Breaker) valid() bool {
+	return b.Threshold > 0 && b.Cooldown.Duration > 0
+}
+
+// oneOf checks that v is exactly one of allowed, which is case sensitive so that values which have not been
+// normalized are caught rather than quietly failing to match where they're used.
+func oneOf(v string, allowed ...string) bool {
+	for _, a := range allowed {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
+
+func validUrl(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Duration is a time.Duration which is written as a string like "1m30s" in config files.
+type Duration struct {
+	time.Duration
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads the duration from a string like "1m30s", or from a number of nanoseconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		d.Duration = time.Duration(value)
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		d.Duration = parsed
+	default:
+		return fmt.Errorf("invalid duration %s", string(b))
+	}
+	return nil
+}
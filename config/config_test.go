@@ -0,0 +1,294 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// env creates an environment lookup from the given variables.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+// tempDir creates a temporary directory, the returned func removes it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "jokesontap-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writeFile writes contents to a file named name in dir.
+func writeFile(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfg, err := Load("", env(nil))
+	assert.Nil(err)
+	assert.Equal(Default(), cfg)
+	assert.Nil(cfg.Validate())
+}
+
+func TestConfigLayering(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := writeFile(t, dir, "config.json", `{
+		"port": 8080,
+		"names": {"budget": 10, "budgetWindow": "2m", "client": {"timeout": "1s"}},
+		"jokes": {"categories": ["explicit"]}
+	}`)
+	cfg, err := Load(path, env(map[string]string{
+		"JOKESONTAP_PORT":          "9000",
+		"JOKESONTAP_NAMES_TIMEOUT": "3s",
+		"JOKESONTAP_LOG_LEVEL":     "debug",
+	}))
+	assert.Nil(err)
+
+	// the environment overrides the file
+	assert.Equal(int32(9000), cfg.Port)
+	assert.Equal(3*time.Second, cfg.Names.Client.Timeout.Duration)
+	assert.Equal("debug", cfg.Log.Level)
+	// the file overrides the defaults
+	assert.Equal(10, cfg.Names.Budget)
+	assert.Equal(2*time.Minute, cfg.Names.BudgetWindow.Duration)
+	assert.Equal([]string{"explicit"}, cfg.Jokes.Categories)
+	// anything not set keeps the default
+	assert.Equal(Default().Names.Url, cfg.Names.Url)
+	assert.Equal(Default().Names.Client.MaxIdleConns, cfg.Names.Client.MaxIdleConns)
+	assert.Equal(Default().Jokes.Client, cfg.Jokes.Client)
+}
+
+func TestYamlConfigFile(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	for _, file := range []string{"config.yaml", "config.yml"} {
+		path := writeFile(t, dir, file, `
+port: 8080
+shutdownGrace: 10s
+names:
+  budget: 10
+  budgetWindow: 2m
+  providers:
+    - name: offline
+      kind: generator
+      budget: 60
+      budgetWindow: 1m
+jokes:
+  categories: [explicit]
+`)
+		cfg, err := Load(path, env(nil))
+		assert.Nil(err, file)
+		assert.Equal(int32(8080), cfg.Port, file)
+		assert.Equal(10*time.Second, cfg.ShutdownGrace.Duration, file)
+		assert.Equal(10, cfg.Names.Budget, file)
+		assert.Equal(2*time.Minute, cfg.Names.BudgetWindow.Duration, file)
+		assert.Equal([]string{"explicit"}, cfg.Jokes.Categories, file)
+		if assert.Len(cfg.Names.Providers, 1, file) {
+			assert.Equal("offline", cfg.Names.Providers[0].Name, file)
+			assert.True(cfg.Names.Providers[0].Generator(), file)
+			assert.Equal(time.Minute, cfg.Names.Providers[0].BudgetWindow.Duration, file)
+		}
+		// anything not set keeps the default
+		assert.Equal(Default().Names.Url, cfg.Names.Url, file)
+		assert.Nil(cfg.Validate(), file)
+	}
+}
+
+func TestInvalidConfigSources(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		name           string
+		file           string
+		contents       string
+		env            map[string]string
+		expErrContains string
+	}{
+		{"bad_json", "config.json", `{"port": `, nil, "unable to parse config file"},
+		{"bad_duration", "config.json", `{"shutdownGrace": "soon"}`, nil, "unable to parse config file"},
+		{"bad_yaml", "config.yaml", "port: [8080", nil, "unable to parse config file"},
+		{"bad_yaml_duration", "config.yaml", "shutdownGrace: soon", nil, "unable to parse config file"},
+		{"unsupported_format", "config.toml", `port = 8080`, nil, "unsupported config file format"},
+		{"bad_env_int", "", "", map[string]string{"JOKESONTAP_PORT": "abc"}, "JOKESONTAP_PORT"},
+		{"bad_env_duration", "", "", map[string]string{"JOKESONTAP_JOKES_REFRESH": "daily"}, "JOKESONTAP_JOKES_REFRESH"},
+		{"bad_env_bool", "", "", map[string]string{"JOKESONTAP_LOG_PRETTY_JSON": "maybe"}, "JOKESONTAP_LOG_PRETTY_JSON"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			if tt.file != "" {
+				path = writeFile(t, dir, tt.name+"-"+tt.file, tt.contents)
+			}
+			_, err := Load(path, env(tt.env))
+			if assert.NotNil(err) {
+				assert.Contains(err.Error(), tt.expErrContains)
+			}
+		})
+	}
+
+	_, err := Load("/does/not/exist.json", env(nil))
+	assert.NotNil(err)
+}
+
+func TestValidatingConfig(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		name           string
+		modify         func(*Config)
+		expErrContains string
+	}{
+		{"zero_port", func(c *Config) { c.Port = 0 }, "port must be between"},
+		{"same_ports", func(c *Config) { c.MetricsPort = int(c.Port) }, "metrics port must differ"},
+		{"same_admin_port", func(c *Config) { c.AdminPort = c.MetricsPort }, "admin port must differ"},
+		{"log_level", func(c *Config) { c.Log.Level = "loud" }, "log level"},
+		{"log_format", func(c *Config) { c.Log.Format = "xml" }, "log format"},
+		{"access_log", func(c *Config) { c.Log.Access = "apache" }, "access log format"},
+		{"names_ready_threshold", func(c *Config) { c.Names.ReadyThreshold = c.Names.ChanSize + 1 }, "names ready threshold"},
+		{"names_url", func(c *Config) { c.Names.Url = "uinames.com" }, "names URL"},
+		{"jokes_url", func(c *Config) { c.Jokes.Url = "ftp://icndb.com" }, "jokes URL"},
+		{"budget", func(c *Config) { c.Names.Budget = 0 }, "names budget must allow"},
+		{"budget_kind", func(c *Config) { c.Names.BudgetKind = "leaky" }, "names budget kind"},
+		{"chan_size", func(c *Config) { c.Names.ChanSize = 0 }, "names channel size"},
+		{"snapshot_interval", func(c *Config) { c.Names.SnapshotInterval.Duration = 0 }, "names snapshot interval"},
+		{"timeout", func(c *Config) { c.Jokes.Client.Timeout.Duration = -time.Second }, "jokes client"},
+		{"refresh", func(c *Config) { c.Jokes.Refresh.Duration = 0 }, "jokes refresh"},
+		{"breaker_threshold", func(c *Config) { c.Jokes.Breaker.Threshold = 0 }, "jokes breaker"},
+		{"breaker_cooldown", func(c *Config) { c.Names.Breaker.Cooldown.Duration = 0 }, "names breaker"},
+		{"provider_default_name", func(c *Config) { c.Names.Providers = []NameProvider{provider("default")} }, "unique name"},
+		{"provider_duplicate_name", func(c *Config) { c.Names.Providers = []NameProvider{provider("a"), provider("a")} }, "unique name"},
+		{"provider_url", func(c *Config) {
+			p := provider("a")
+			p.Url = "names"
+			c.Names.Providers = []NameProvider{p}
+		}, "name provider 'a': URL"},
+		{"provider_budget", func(c *Config) {
+			p := provider("a")
+			p.Budget = 0
+			c.Names.Providers = []NameProvider{p}
+		}, "name provider 'a': budget"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if assert.NotNil(err) {
+				assert.Contains(err.Error(), tt.expErrContains)
+			}
+		})
+	}
+}
+
+// provider creates a valid name provider configuration.
+func provider(name string) NameProvider {
+	return NameProvider{Name: name, Url: "https://names.example.com", Budget: 1, BudgetWindow: Duration{time.Second}}
+}
+
+func TestGeneratorNameProviders(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfg := Default()
+	cfg.Names.Providers = []NameProvider{{Name: "offline", Kind: "generator", Priority: 10}}
+	assert.NotNil(cfg.Validate(), "generators need a budget")
+
+	cfg.Names.Providers[0].Budget = 60
+	cfg.Names.Providers[0].BudgetWindow = Duration{time.Minute}
+	assert.Nil(cfg.Validate(), "generators don't need a URL")
+
+	cfg.Names.Providers[0].Gender = "other"
+	assert.NotNil(cfg.Validate())
+}
+
+func TestNameProvidersFromEnv(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfg, err := Load("", env(map[string]string{
+		"JOKESONTAP_NAMES_PROVIDERS": `[{"name": "backup", "url": "https://names.example.com", "budget": 10, "budgetWindow": "1m", "priority": 1}]`,
+	}))
+	assert.Nil(err)
+	assert.Nil(cfg.Validate())
+	if assert.Len(cfg.Names.Providers, 1) {
+		assert.Equal("backup", cfg.Names.Providers[0].Name)
+		assert.Equal(time.Minute, cfg.Names.Providers[0].BudgetWindow.Duration)
+		assert.Equal(1, cfg.Names.Providers[0].Priority)
+	}
+}
+
+func TestConfigStringIsJson(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s := Default().String()
+	assert.Contains(s, `"port":5000`)
+	assert.Contains(s, `"budgetWindow":"1m1s"`)
+}
+
+func TestConfigStringRedactsSecrets(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfg := Default()
+	cfg.AdminToken = "hunter2"
+	s := cfg.String()
+	assert.NotContains(s, "hunter2")
+	assert.Contains(s, `"adminToken":"redacted"`)
+	assert.Equal("hunter2", cfg.AdminToken)
+}
+
+func TestLoadNormalizesEnumValues(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	cfg, err := Load("", env(map[string]string{
+		"JOKESONTAP_LOG_LEVEL":         "DEBUG",
+		"JOKESONTAP_LOG_FORMAT":        " Json ",
+		"JOKESONTAP_NAMES_BUDGET_KIND": "Token",
+		"JOKESONTAP_NAMES_PROVIDERS":   `[{"name": "offline", "kind": "Generator", "gender": "Female", "budgetKind": "SLIDING", "budget": 1, "budgetWindow": "1m"}]`,
+	}))
+	assert.Nil(err)
+	assert.Nil(cfg.Validate())
+	assert.Equal("debug", cfg.Log.Level)
+	assert.Equal("json", cfg.Log.Format)
+	assert.Equal("token", cfg.Names.BudgetKind)
+	if assert.Len(cfg.Names.Providers, 1) {
+		p := cfg.Names.Providers[0]
+		assert.Equal("generator", p.Kind)
+		assert.Equal("female", p.Gender)
+		assert.Equal("sliding", p.BudgetKind)
+	}
+
+	// values which haven't been normalized aren't valid, as they wouldn't match where they're used
+	cfg.Names.BudgetKind = "Token"
+	assert.NotNil(cfg.Validate())
+}
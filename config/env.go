@@ -0,0 +1,157 @@
+package config
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envVar is a single configuration value which can be set from the environment.
+type envVar struct {
+	// name is the variable name without the EnvPrefix.
+	name string
+	set  func(string) error
+}
+
+// envVars are all configuration values which can be set from the environment.
+func (c *Config) envVars() []envVar {
+	return []envVar{
+		{"PORT", setInt32(&c.Port)},
+		{"METRICS_PORT", setInt(&c.MetricsPort)},
+		{"ADMIN_PORT", setInt(&c.AdminPort)},
+		{"ADMIN_TOKEN", setString(&c.AdminToken)},
+		{"SHUTDOWN_GRACE", setDuration(&c.ShutdownGrace)},
+		{"TRUST_USER_NAME_HEADER", setBool(&c.TrustUserNameHeader)},
+		{"LOG_LEVEL", setString(&c.Log.Level)},
+		{"LOG_FORMAT", setString(&c.Log.Format)},
+		{"LOG_PRETTY_JSON", setBool(&c.Log.PrettyJson)},
+		{"LOG_ACCESS", setString(&c.Log.Access)},
+		{"LOG_REVERT_AFTER", setDuration(&c.Log.RevertAfter)},
+		{"NAMES_URL", setString(&c.Names.Url)},
+		{"NAMES_CHAN_SIZE", setInt(&c.Names.ChanSize)},
+		{"NAMES_READY_THRESHOLD", setInt(&c.Names.ReadyThreshold)},
+		{"NAMES_BUDGET", setInt(&c.Names.Budget)},
+		{"NAMES_BUDGET_WINDOW", setDuration(&c.Names.BudgetWindow)},
+		{"NAMES_BUDGET_KIND", setString(&c.Names.BudgetKind)},
+		{"NAMES_TIMEOUT", setDuration(&c.Names.Client.Timeout)},
+		{"NAMES_MAX_IDLE_CONNS", setInt(&c.Names.Client.MaxIdleConns)},
+		{"NAMES_IDLE_CONN_TIMEOUT", setDuration(&c.Names.Client.IdleConnTimeout)},
+		{"NAMES_DISABLE_COMPRESSION", setBool(&c.Names.Client.DisableCompression)},
+		{"NAMES_RETRIES", setInt(&c.Names.Client.Retry.Retries)},
+		{"NAMES_RETRY_BASE_DELAY", setDuration(&c.Names.Client.Retry.BaseDelay)},
+		{"NAMES_RETRY_MAX_DELAY", setDuration(&c.Names.Client.Retry.MaxDelay)},
+		{"NAMES_RETRY_BUDGET", setDuration(&c.Names.Client.Retry.Budget)},
+		{"NAMES_BREAKER_THRESHOLD", setInt(&c.Names.Breaker.Threshold)},
+		{"NAMES_BREAKER_COOLDOWN", setDuration(&c.Names.Breaker.Cooldown)},
+		{"NAME_CACHE_SIZE", setInt(&c.Names.Cache.Size)},
+		{"NAME_CACHE_TTL", setDuration(&c.Names.Cache.TTL)},
+		{"NAMES_SNAPSHOT", setString(&c.Names.Snapshot)},
+		{"NAMES_SNAPSHOT_INTERVAL", setDuration(&c.Names.SnapshotInterval)},
+		{"NAMES_PROVIDERS", setJson(&c.Names.Providers)},
+		{"JOKES_URL", setString(&c.Jokes.Url)},
+		{"JOKES_CORPUS_URL", setString(&c.Jokes.CorpusUrl)},
+		{"JOKES_REFRESH", setDuration(&c.Jokes.Refresh)},
+		{"JOKES_CATEGORIES_URL", setString(&c.Jokes.CategoriesUrl)},
+		{"JOKES_CATEGORIES", setStrings(&c.Jokes.Categories)},
+		{"JOKES_MAX_BATCH", setInt(&c.Jokes.MaxBatch)},
+		{"JOKES_TIMEOUT", setDuration(&c.Jokes.Client.Timeout)},
+		{"JOKES_MAX_IDLE_CONNS", setInt(&c.Jokes.Client.MaxIdleConns)},
+		{"JOKES_IDLE_CONN_TIMEOUT", setDuration(&c.Jokes.Client.IdleConnTimeout)},
+		{"JOKES_DISABLE_COMPRESSION", setBool(&c.Jokes.Client.DisableCompression)},
+		{"JOKES_RETRIES", setInt(&c.Jokes.Client.Retry.Retries)},
+		{"JOKES_RETRY_BASE_DELAY", setDuration(&c.Jokes.Client.Retry.BaseDelay)},
+		{"JOKES_RETRY_MAX_DELAY", setDuration(&c.Jokes.Client.Retry.MaxDelay)},
+		{"JOKES_RETRY_BUDGET", setDuration(&c.Jokes.Client.Retry.Budget)},
+		{"JOKES_BREAKER_THRESHOLD", setInt(&c.Jokes.Breaker.Threshold)},
+		{"JOKES_BREAKER_COOLDOWN", setDuration(&c.Jokes.Breaker.Cooldown)},
+		{"CLIENT_PIN_SIZE", setInt(&c.ClientPins.Size)},
+		{"CLIENT_PIN_TTL", setDuration(&c.ClientPins.TTL)},
+	}
+}
+
+// applyEnv overrides the configuration with any values set in the environment.
+func (c *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	for _, v := range c.envVars() {
+		value, ok := lookupEnv(EnvPrefix + v.name)
+		if !ok {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			return errors.Wrapf(err, "invalid value for environment variable %s%s", EnvPrefix, v.name)
+		}
+	}
+	return nil
+}
+
+func setString(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*p = i
+		return nil
+	}
+}
+
+func setInt32(p *int32) func(string) error {
+	return func(v string) error {
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return err
+		}
+		*p = int32(i)
+		return nil
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*p = b
+		return nil
+	}
+}
+
+func setDuration(p *Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		p.Duration = d
+		return nil
+	}
+}
+
+// setStrings sets a list from comma separated values.  An empty value sets an empty list.
+func setStrings(p *[]string) func(string) error {
+	return func(v string) error {
+		*p = []string{}
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*p = append(*p, s)
+			}
+		}
+		return nil
+	}
+}
+
+// setJson sets values which can't be written as a single string, like lists, from JSON.
+func setJson(p interface{}) func(string) error {
+	return func(v string) error {
+		return json.Unmarshal([]byte(v), p)
+	}
+}
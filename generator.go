@@ -0,0 +1,87 @@
+package jokesontap
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+const (
+	GenderMale   = "male"
+	GenderFemale = "female"
+)
+
+// NameGenerator is a NameRequester which generates names from lists built into the server rather than requesting
+// them from a names API, so it works without network access and is never rate limited.
+type NameGenerator struct {
+	// BatchSize is how many names are generated for each call to Names.
+	BatchSize int
+
+	// first and last are the names picked from, after filtering by locale and gender.
+	first []string
+	last  []string
+
+	// mu guards rand, which is not safe for concurrent use.
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewNameGenerator creates a NameGenerator with default values which picks names for the given locale and gender.
+// An empty locale picks names from every locale and an empty gender picks names of either gender.  The same seed
+// always generates the same names.
+func NewNameGenerator(locale, gender string, seed int64) (*NameGenerator, error) {
+	var locales []string
+	if locale == "" {
+		locales = NameLocales()
+	} else if _, ok := generatorNames[locale]; ok {
+		locales = []string{locale}
+	} else {
+		return nil, fmt.Errorf("unsupported name generator locale '%s', locale should be one of %v", locale, NameLocales())
+	}
+	if gender != "" && gender != GenderMale && gender != GenderFemale {
+		return nil, fmt.Errorf("unsupported name generator gender '%s', gender should be one of %s, %s", gender, GenderMale, GenderFemale)
+	}
+
+	g := &NameGenerator{
+		BatchSize: 500,
+		rand:      rand.New(rand.NewSource(seed)),
+	}
+	for _, l := range locales {
+		names := generatorNames[l]
+		if gender != GenderFemale {
+			g.first = append(g.first, names.male...)
+		}
+		if gender != GenderMale {
+			g.first = append(g.first, names.female...)
+		}
+		g.last = append(g.last, names.surname...)
+	}
+	return g, nil
+}
+
+// Names generates a batch of BatchSize names.  Names never fails.
+func (g *NameGenerator) Names(ctx context.Context) ([]Name, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	names := make([]Name, g.BatchSize)
+	for i := range names {
+		names[i] = Name{
+			Name:    g.first[g.rand.Intn(len(g.first))],
+			Surname: g.last[g.rand.Intn(len(g.last))],
+		}
+	}
+	return names, nil
+}
+
+// NameLocales gets the locales supported by NameGenerator.
+func NameLocales() []string {
+	locales := make([]string, 0, len(generatorNames))
+	for l := range generatorNames {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+	return locales
+}
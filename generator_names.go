@@ -0,0 +1,86 @@
+package jokesontap
+
+// localeNames are the names a NameGenerator picks from for a single locale.
+type localeNames struct {
+	male    []string
+	female  []string
+	surname []string
+}
+
+// generatorNames holds the names used by NameGenerator, keyed by locale.  Names are kept in source rather than
+// loaded from files so that the generator works anywhere the binary does.
+var generatorNames = map[string]localeNames{
+	"en_US": {
+		male: []string{
+			"James", "John", "Robert", "Michael", "William", "David", "Richard", "Joseph", "Thomas", "Charles",
+			"Christopher", "Daniel", "Matthew", "Anthony", "Mark", "Donald", "Steven", "Paul", "Andrew", "Joshua",
+			"Kenneth", "Kevin", "Brian", "George", "Timothy", "Ronald", "Edward", "Jason", "Jeffrey", "Ryan",
+		},
+		female: []string{
+			"Mary", "Patricia", "Jennifer", "Linda", "Elizabeth", "Barbara", "Susan", "Jessica", "Sarah", "Karen",
+			"Lisa", "Nancy", "Betty", "Margaret", "Sandra", "Ashley", "Kimberly", "Emily", "Donna", "Michelle",
+			"Carol", "Amanda", "Dorothy", "Melissa", "Deborah", "Stephanie", "Rebecca", "Sharon", "Laura", "Cynthia",
+		},
+		surname: []string{
+			"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez",
+			"Hernandez", "Lopez", "Wilson", "Anderson", "Taylor", "Moore", "Jackson", "Martin", "Lee", "Thompson",
+			"White", "Harris", "Clark", "Lewis", "Robinson", "Walker", "Young", "Allen", "King", "Wright",
+		},
+	},
+	"en_GB": {
+		male: []string{
+			"Oliver", "George", "Harry", "Jack", "Jacob", "Noah", "Charlie", "Thomas", "Oscar", "William",
+			"James", "Henry", "Leo", "Alfie", "Joshua", "Freddie", "Archie", "Ethan", "Isaac", "Alexander",
+		},
+		female: []string{
+			"Olivia", "Amelia", "Isla", "Ava", "Emily", "Sophia", "Grace", "Mia", "Poppy", "Ella",
+			"Lily", "Evie", "Isabella", "Sophie", "Ivy", "Freya", "Harper", "Willow", "Charlotte", "Jessica",
+		},
+		surname: []string{
+			"Smith", "Jones", "Taylor", "Brown", "Williams", "Wilson", "Johnson", "Davies", "Patel", "Robinson",
+			"Wright", "Thompson", "Evans", "Walker", "White", "Roberts", "Green", "Hall", "Thomas", "Clarke",
+		},
+	},
+	"de_DE": {
+		male: []string{
+			"Lukas", "Leon", "Finn", "Jonas", "Paul", "Felix", "Elias", "Maximilian", "Ben", "Noah",
+			"Luis", "Henry", "Emil", "Anton", "Jakob", "Moritz", "Theo", "Matteo", "David", "Julian",
+		},
+		female: []string{
+			"Mia", "Emma", "Hannah", "Sofia", "Anna", "Emilia", "Lina", "Marie", "Lena", "Mila",
+			"Clara", "Leonie", "Lea", "Ella", "Johanna", "Luisa", "Frieda", "Ida", "Greta", "Charlotte",
+		},
+		surname: []string{
+			"Müller", "Schmidt", "Schneider", "Fischer", "Weber", "Meyer", "Wagner", "Becker", "Schulz", "Hoffmann",
+			"Schäfer", "Koch", "Bauer", "Richter", "Klein", "Wolf", "Schröder", "Neumann", "Schwarz", "Zimmermann",
+		},
+	},
+	"fr_FR": {
+		male: []string{
+			"Gabriel", "Léo", "Raphaël", "Louis", "Lucas", "Adam", "Jules", "Hugo", "Arthur", "Nathan",
+			"Paul", "Tom", "Théo", "Noah", "Ethan", "Mathis", "Sacha", "Nolan", "Victor", "Antoine",
+		},
+		female: []string{
+			"Emma", "Jade", "Louise", "Alice", "Chloé", "Lina", "Léa", "Rose", "Anna", "Mila",
+			"Inès", "Ambre", "Julia", "Manon", "Zoé", "Camille", "Léna", "Juliette", "Lou", "Agathe",
+		},
+		surname: []string{
+			"Martin", "Bernard", "Thomas", "Petit", "Robert", "Richard", "Durand", "Dubois", "Moreau", "Laurent",
+			"Simon", "Michel", "Lefebvre", "Leroy", "Roux", "David", "Bertrand", "Morel", "Fournier", "Girard",
+		},
+	},
+	"es_ES": {
+		male: []string{
+			"Hugo", "Martín", "Lucas", "Mateo", "Leo", "Daniel", "Alejandro", "Pablo", "Manuel", "Álvaro",
+			"Adrián", "Enzo", "Mario", "Diego", "David", "Javier", "Marcos", "Sergio", "Carlos", "Miguel",
+		},
+		female: []string{
+			"Lucía", "Sofía", "Martina", "María", "Julia", "Paula", "Valeria", "Emma", "Daniela", "Carla",
+			"Alba", "Noa", "Alma", "Sara", "Carmen", "Vega", "Lara", "Mía", "Valentina", "Olivia",
+		},
+		surname: []string{
+			"García", "Rodríguez", "González", "Fernández", "López", "Martínez", "Sánchez", "Pérez", "Gómez", "Martín",
+			"Jiménez", "Ruiz", "Hernández", "Díaz", "Moreno", "Muñoz", "Álvarez", "Romero", "Alonso", "Gutiérrez",
+		},
+	},
+}
@@ -0,0 +1,82 @@
+package jokesontap
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGeneratingNames(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	g, err := NewNameGenerator("", "", 1)
+	assert.Nil(err)
+	g.BatchSize = 50
+	names, err := g.Names(context.Background())
+	assert.Nil(err)
+	assert.Len(names, 50)
+	for _, n := range names {
+		assert.NotEmpty(n.Name)
+		assert.NotEmpty(n.Surname)
+	}
+}
+
+func TestGeneratingNamesWithSeedIsRepeatable(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	generate := func(seed int64) []Name {
+		g, err := NewNameGenerator("en_US", "", seed)
+		assert.Nil(err)
+		names, err := g.Names(context.Background())
+		assert.Nil(err)
+		return names
+	}
+	assert.Equal(generate(42), generate(42))
+	assert.NotEqual(generate(42), generate(43))
+}
+
+func TestGeneratingNamesByLocaleAndGender(t *testing.T) {
+	t.Parallel()
+
+	contains := func(list []string, s string) bool {
+		for _, l := range list {
+			if l == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, locale := range NameLocales() {
+		for _, gender := range []string{GenderMale, GenderFemale} {
+			t.Run(locale+"_"+gender, func(t *testing.T) {
+				assert := assert.New(t)
+				g, err := NewNameGenerator(locale, gender, 1)
+				assert.Nil(err)
+				names, err := g.Names(context.Background())
+				assert.Nil(err)
+
+				expFirst := generatorNames[locale].male
+				if gender == GenderFemale {
+					expFirst = generatorNames[locale].female
+				}
+				for _, n := range names {
+					assert.True(contains(expFirst, n.Name), "unexpected first name %s", n.Name)
+					assert.True(contains(generatorNames[locale].surname, n.Surname), "unexpected surname %s", n.Surname)
+				}
+			})
+		}
+	}
+}
+
+func TestGeneratingNamesWithUnsupportedFilters(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	_, err := NewNameGenerator("xx_XX", "", 1)
+	assert.NotNil(err)
+	_, err = NewNameGenerator("en_US", "robot", 1)
+	assert.NotNil(err)
+}
@@ -8,4 +8,5 @@ require (
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.5
 	github.com/stretchr/testify v1.3.0
+	gopkg.in/yaml.v2 v2.4.0
 )
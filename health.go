@@ -0,0 +1,218 @@
+package jokesontap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/swtch1/jokesontap/breaker"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var ErrNoHealthyNameProviders = errors.New("no name provider is healthy")
+
+// Checker checks whether something the server depends on is ready, returning an error which says why when it isn't.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is a func used as a Checker.
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls f.
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Readiness decides whether the server is ready to serve jokes by running every registered check.  The server is
+// ready when all checks pass.
+type Readiness struct {
+	// Timeout is how long every check together may take, checks which take longer fail.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	names  []string
+	checks map[string]Checker
+}
+
+// NewReadiness creates Readiness with default values and no checks.
+func NewReadiness() *Readiness {
+	return &Readiness{
+		Timeout: 2 * time.Second,
+		checks:  make(map[string]Checker),
+	}
+}
+
+// Register adds a check named name, replacing any check already registered with the name.
+func (r *Readiness) Register(name string, c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.checks[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.checks[name] = c
+}
+
+// ReadinessReport is the outcome of every readiness check.
+type ReadinessReport struct {
+	Ready  bool          `json:"ready"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// CheckResult is the outcome of a single readiness check.
+type CheckResult struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+	// Error says why the check failed.
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"durationSeconds"`
+}
+
+// Check runs every check at once, in the order they were registered, until they're done or ctx is.
+func (r *Readiness) Check(ctx context.Context) ReadinessReport {
+	r.mu.Lock()
+	names := append([]string(nil), r.names...)
+	checks := make([]Checker, len(names))
+	for i, name := range names {
+		checks[i] = r.checks[name]
+	}
+	r.mu.Unlock()
+
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	report := ReadinessReport{Ready: true, Checks: make([]CheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(ctx, names[i], checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for _, c := range report.Checks {
+		report.Ready = report.Ready && c.Ready
+	}
+	return report
+}
+
+// runCheck runs c, failing it when ctx is done first.
+func runCheck(ctx context.Context, name string, c Checker) CheckResult {
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "check did not finish in time")
+	}
+
+	result := CheckResult{Name: name, Ready: err == nil, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Healthz serves 200 for as long as the server is able to serve requests at all.
+func (s *Server) Healthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentTypeText+"; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "ok")
+}
+
+// Readyz serves the outcome of every readiness check as JSON, with 200 when the server is ready to serve jokes or
+// 503 otherwise.  Without Readiness the server is always ready.
+func (s *Server) Readyz(w http.ResponseWriter, req *http.Request) {
+	report := ReadinessReport{Ready: true, Checks: []CheckResult{}}
+	if s.Readiness != nil {
+		report = s.Readiness.Check(req.Context())
+	}
+	if !report.Ready {
+		log.WithContext(req.Context()).WithField("checks", report.Checks).Debug("server is not ready")
+	}
+
+	w.Header().Set("Content-Type", contentTypeJson)
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.WithContext(req.Context()).WithError(err).Error("unable to write readiness response")
+	}
+}
+
+// NamesPoolCheck passes once names holds at least threshold names.  After that it always passes, so the server isn't
+// taken out of service when it is busiest and is reusing cached names.
+func NamesPoolCheck(names chan Name, threshold int) Checker {
+	var mu sync.Mutex
+	filled := false
+	return CheckerFunc(func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if filled || len(names) >= threshold {
+			filled = true
+			return nil
+		}
+		return fmt.Errorf("names pool holds %d of the %d names needed", len(names), threshold)
+	})
+}
+
+// BreakerCheck fails while b is open.
+func BreakerCheck(b *breaker.Breaker) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		if b.State() == breaker.Open {
+			return fmt.Errorf("circuit breaker '%s' is open", b.Name)
+		}
+		return nil
+	})
+}
+
+// NameProvidersCheck fails while every one of p's providers is cooling down after a failed request.
+func NameProvidersCheck(p *NameProviders) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		for _, s := range p.Stats() {
+			if s.Healthy {
+				return nil
+			}
+		}
+		return ErrNoHealthyNameProviders
+	})
+}
+
+// ReachableCheck fails when a connection can't be made to the host serving u.  No request is sent, so the check
+// doesn't count against the upstream API's rate limits.
+func ReachableCheck(u url.URL) Checker {
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	return CheckerFunc(func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return errors.Wrapf(err, "unable to reach '%s'", addr)
+		}
+		return conn.Close()
+	})
+}
@@ -0,0 +1,137 @@
+package jokesontap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/swtch1/jokesontap/breaker"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHealthzIsAlwaysOk(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	(&Server{}).Healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("ok\n", w.Body.String())
+}
+
+func TestReadyzReportsEveryCheck(t *testing.T) {
+	t.Parallel()
+
+	pass := CheckerFunc(func(ctx context.Context) error { return nil })
+	fail := CheckerFunc(func(ctx context.Context) error { return errors.New("down") })
+	hang := CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	tests := []struct {
+		name      string
+		checks    map[string]Checker
+		expStatus int
+		expFailed []string
+	}{
+		{"no_checks", nil, http.StatusOK, nil},
+		{"all_pass", map[string]Checker{"a": pass, "b": pass}, http.StatusOK, nil},
+		{"one_fails", map[string]Checker{"a": pass, "b": fail}, http.StatusServiceUnavailable, []string{"b"}},
+		{"too_slow", map[string]Checker{"a": hang}, http.StatusServiceUnavailable, []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+			r := NewReadiness()
+			r.Timeout = 20 * time.Millisecond
+			for name, c := range tt.checks {
+				r.Register(name, c)
+			}
+
+			w := httptest.NewRecorder()
+			(&Server{Readiness: r}).Readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+			assert.Equal(tt.expStatus, w.Code)
+			assert.Equal(contentTypeJson, w.Header().Get("Content-Type"))
+
+			var report ReadinessReport
+			assert.Nil(json.NewDecoder(w.Body).Decode(&report))
+			assert.Equal(tt.expStatus == http.StatusOK, report.Ready)
+			assert.Len(report.Checks, len(tt.checks))
+			var failed []string
+			for _, c := range report.Checks {
+				if !c.Ready {
+					assert.NotEmpty(c.Error)
+					failed = append(failed, c.Name)
+				}
+			}
+			assert.Equal(tt.expFailed, failed)
+		})
+	}
+}
+
+func TestNamesPoolCheckStaysReadyOnceFilled(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	names := make(chan Name, 3)
+	check := NamesPoolCheck(names, 2)
+	names <- Name{Name: "Ada"}
+	assert.NotNil(check.Check(context.Background()))
+
+	names <- Name{Name: "Grace"}
+	assert.Nil(check.Check(context.Background()))
+
+	<-names
+	<-names
+	assert.Nil(check.Check(context.Background()), "an emptied pool keeps the server ready")
+}
+
+func TestBreakerCheck(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	b := breaker.New("jokes", 1, time.Minute)
+	check := BreakerCheck(b)
+	assert.Nil(check.Check(context.Background()))
+
+	done, err := b.Allow()
+	assert.Nil(err)
+	done(breaker.Failure)
+	assert.NotNil(check.Check(context.Background()))
+}
+
+func TestNameProvidersCheck(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	failing := &NameProvider{Name: "failing", Requester: &stubNameRequester{name: "failing", err: errors.New("down")}}
+	p := NewNameProviders(failing)
+	check := NameProvidersCheck(p)
+	assert.Nil(check.Check(context.Background()))
+
+	_, err := p.Names(context.Background())
+	assert.NotNil(err)
+	assert.Equal(ErrNoHealthyNameProviders, check.Check(context.Background()))
+}
+
+func TestReachableCheck(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.NotFoundHandler())
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	check := ReachableCheck(*u)
+	assert.Nil(check.Check(context.Background()))
+
+	ts.Close()
+	assert.NotNil(check.Check(context.Background()))
+}
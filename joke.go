@@ -1,26 +1,46 @@
 package jokesontap
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/swtch1/jokesontap/breaker"
+	"github.com/swtch1/jokesontap/metric"
+	"github.com/swtch1/jokesontap/retry"
 	"html"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
-var ErrUnsuccessfulJokeQuery = errors.New("general error getting new joke")
+var (
+	ErrUnsuccessfulJokeQuery = errors.New("general error getting new joke")
+	ErrJokeNotFound          = errors.New("no joke exists with the requested ID")
+	// ErrNon200JokeApiResponse occurs when the jokes API responds with a server error.
+	ErrNon200JokeApiResponse   = errors.New("general error in jokes API response")
+	ErrJokesApiTooManyRequests = errors.New("too many requests to jokes API")
+)
+
+// noSuchJoke is the response type from the jokes API when a joke is requested by an unknown ID.
+const noSuchJoke = "NoSuchQuoteException"
 
 // Joke maps to the Internet Chuck Norris database API response.
 type Joke struct {
-	Type  string `json:"type"`
-	Value struct {
-		ID   int    `json:"id"`
-		Joke string `json:"joke"`
-	} `json:"value"`
+	Type  string    `json:"type"`
+	Value JokeValue `json:"value"`
+}
+
+// JokeValue is a single joke as stored in the Internet Chuck Norris database.
+type JokeValue struct {
+	ID         int      `json:"id"`
+	Joke       string   `json:"joke"`
+	Categories []string `json:"categories"`
 }
 
 // Successful returns true when a populated Joke response was successful.
@@ -36,71 +56,273 @@ func (j *Joke) Successful() bool {
 type JokeClient struct {
 	// ApiUrl is the base URL of the jokes API to query
 	ApiUrl url.URL
+	// CategoriesUrl is the URL of the jokes API which lists every joke category.
+	CategoriesUrl url.URL
+	// JokesUrl is the URL of the jokes API under which each joke is found by its ID.
+	JokesUrl url.URL
 	// HttpClient is a http client which can be reused across multiple requests.
 	HttpClient *http.Client
+	// Retry retries requests which fail for reasons likely to pass.
+	Retry retry.Policy
+	// Breaker, when set, fails requests at once while the jokes API keeps failing.
+	Breaker *breaker.Breaker
 }
 
 // NewJokeClient creates a JokeClient with default values where baseUrl is the API URL without any parameters.
+// Categories are listed from /categories and jokes are found by ID under /jokes on the same host.
 func NewJokeClient(baseUrl url.URL) *JokeClient {
-	// TODO: the client values here _may_ be too detailed for the command line, but could be taken in thorough a more
-	// TODO: detailed config file or env vars.  just would be better to be able to dynamically configure.
+	categoriesUrl := baseUrl
+	categoriesUrl.Path = "/categories"
+	categoriesUrl.RawQuery = ""
+	jokesUrl := baseUrl
+	jokesUrl.Path = "/jokes"
+	jokesUrl.RawQuery = ""
 	return &JokeClient{
-		ApiUrl: baseUrl,
-		HttpClient: &http.Client{
-			Timeout: 5 * time.Second,
-			Transport: &http.Transport{
-				DisableCompression: true,
-				MaxIdleConns:       10,
-				IdleConnTimeout:    30 * time.Second,
-			},
-		},
+		ApiUrl:        baseUrl,
+		CategoriesUrl: categoriesUrl,
+		JokesUrl:      jokesUrl,
+		HttpClient:    NewHttpClient(DefaultHttpClientOpts),
+		Retry:         DefaultRetryPolicy,
+	}
+}
+
+// Joke returns a new joke.  The request to the jokes API is abandoned when ctx is done.
+func (c *JokeClient) Joke(ctx context.Context) (string, error) {
+	log.WithContext(ctx).Trace("getting default joke")
+	joke, err := c.jokeFromUrl(ctx, c.ApiUrl.String())
+	return joke.Joke, err
+}
+
+// placeholderAttempts is how many times the jokes API is asked for jokes before giving up on getting enough with a
+// name to substitute.
+const placeholderAttempts = 3
+
+// JokeWithCustomName gets a new joke passing filter using the first and last name passed in.  The joke is requested
+// with the default name and the custom name is substituted locally, so jokes without the default name are skipped
+// like the JokeStore does.  The request to the jokes API is abandoned when ctx is done.
+func (c *JokeClient) JokeWithCustomName(ctx context.Context, fName, lName string, filter CategoryFilter) (JokeValue, error) {
+	log.WithContext(ctx).Trace("getting joke with custom name")
+	for attempt := 0; attempt < placeholderAttempts; attempt++ {
+		joke, err := c.jokeFromUrl(ctx, addParams(c.ApiUrl, filter))
+		if err != nil {
+			return JokeValue{}, err
+		}
+		if !hasPlaceholder(joke.Joke) {
+			log.WithContext(ctx).WithField("id", joke.ID).Debug("skipping joke without a name to substitute")
+			continue
+		}
+		joke.Joke, err = substituteName(joke.Joke, fName, lName)
+		if err != nil {
+			return JokeValue{}, err
+		}
+		return joke, nil
 	}
+	return JokeValue{}, errors.Wrapf(ErrNameNotSubstituted, "no joke with a name to substitute after %d attempts", placeholderAttempts)
 }
 
-// Joke returns a new joke.
-func (c *JokeClient) Joke() (string, error) {
-	log.Trace("getting default joke")
-	return c.jokeFromUrl(c.ApiUrl.String())
+// JokeByID gets the joke with the given ID, with the default name.  An ErrJokeNotFound error is returned if the jokes
+// API has no joke with the ID.  The request to the jokes API is abandoned when ctx is done.
+func (c *JokeClient) JokeByID(ctx context.Context, id int) (JokeValue, error) {
+	log.WithContext(ctx).WithField("id", id).Trace("getting joke by ID")
+	u := c.JokesUrl
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strconv.Itoa(id)
+	return c.jokeFromUrl(ctx, u.String())
 }
 
-// JokeWithCustomName gets a new joke using the first and last name passed in.
-func (c *JokeClient) JokeWithCustomName(fName, lName string) (string, error) {
-	log.Trace("getting joke with custom name")
-	return c.jokeFromUrl(addParams(c.ApiUrl, fName, lName, "nerdy"))
+// JokesWithCustomNames gets a new joke passing filter for each of names, substituting each name into its own joke.
+// All jokes are requested at once with the default name and the custom names are substituted locally.  Jokes without
+// the default name are skipped and more jokes are requested in their place.  The request to the jokes API is
+// abandoned when ctx is done.
+func (c *JokeClient) JokesWithCustomNames(ctx context.Context, names []Name, filter CategoryFilter) ([]JokeValue, error) {
+	log.WithContext(ctx).WithField("count", len(names)).Trace("getting jokes with custom names")
+	jokes := make([]JokeValue, 0, len(names))
+	for attempt := 0; attempt < placeholderAttempts && len(jokes) < len(names); attempt++ {
+		want := len(names) - len(jokes)
+		// ref: http://www.icndb.com/api/ the random jokes URL takes the number of jokes as a final path segment
+		u := c.ApiUrl
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strconv.Itoa(want)
+		batch, err := c.jokesFromUrl(ctx, addParams(u, filter))
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) < want {
+			return nil, errors.Wrapf(ErrUnsuccessfulJokeQuery, "asked for %d jokes but got %d", want, len(batch))
+		}
+		for _, joke := range batch[:want] {
+			if !hasPlaceholder(joke.Joke) {
+				log.WithContext(ctx).WithField("id", joke.ID).Debug("skipping joke without a name to substitute")
+				continue
+			}
+			jokes = append(jokes, joke)
+		}
+	}
+	if len(jokes) < len(names) {
+		return nil, errors.Wrapf(ErrNameNotSubstituted, "only %d of %d jokes had a name to substitute after %d attempts", len(jokes), len(names), placeholderAttempts)
+	}
+
+	for i, name := range names {
+		var err error
+		jokes[i].Joke, err = substituteName(jokes[i].Joke, name.Name, name.Surname)
+		if err != nil {
+			return nil, errors.Wrapf(err, "joke %d", jokes[i].ID)
+		}
+	}
+	return jokes, nil
 }
 
-func (c JokeClient) jokeFromUrl(apiUrl string) (string, error) {
-	req, err := http.NewRequest("GET", apiUrl, nil)
+func (c JokeClient) jokeFromUrl(ctx context.Context, apiUrl string) (joke JokeValue, err error) {
+	done, err := c.Breaker.Allow()
+	if err != nil {
+		return JokeValue{}, err
+	}
+	defer func() { done(upstreamResult(err)) }()
+	start := time.Now()
+	defer func() { metric.ObserveUpstream(metric.UpstreamJokes, start, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiUrl, nil)
 	if err != nil {
-		return "", errors.Wrapf(err, "unable to create new http request with URL '%s'", apiUrl)
+		return JokeValue{}, errors.Wrapf(err, "unable to create new http request with URL '%s'", apiUrl)
 	}
 	req.Header.Set("Accept", "application/json")
-	resp, err := c.HttpClient.Do(req)
+	forwardRequestID(req)
+	resp, err := c.Retry.Do(c.HttpClient, req)
 	if err != nil {
-		return "", errors.Wrapf(err, "unable to get new joke from '%s'", apiUrl)
+		return JokeValue{}, errors.Wrapf(err, "unable to get new joke from '%s'", apiUrl)
 	}
 	defer resp.Body.Close()
+	if err := checkJokesApiStatus(resp); err != nil {
+		return JokeValue{}, err
+	}
 
-	var joke Joke
+	var j Joke
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to read jokes API response body")
+		return JokeValue{}, errors.Wrap(err, "unable to read jokes API response body")
+	}
+	// an unknown joke has a message rather than a joke as its value, so check the type before the value is decoded
+	var status struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(body, &status); err == nil && status.Type == noSuchJoke {
+		return JokeValue{}, ErrJokeNotFound
 	}
-	if err := json.Unmarshal(body, &joke); err != nil {
-		return "", errors.Wrap(err, "unable to unmarshal jokes API response body")
+	if err := json.Unmarshal(body, &j); err != nil {
+		return JokeValue{}, errors.Wrap(err, "unable to unmarshal jokes API response body")
 	}
-	if !joke.Successful() {
-		return "", ErrUnsuccessfulJokeQuery
+	if !j.Successful() {
+		return JokeValue{}, ErrUnsuccessfulJokeQuery
 	}
-	return html.UnescapeString(joke.Value.Joke), nil
+	j.Value.Joke = html.UnescapeString(j.Value.Joke)
+	return j.Value, nil
 }
 
-// addParams will add the first name, last name, and category as parameters to url.
-func addParams(baseUrl url.URL, fName, lName, category string) string {
+// Categories lists every joke category the jokes API knows about.  The request to the jokes API is abandoned when
+// ctx is done.
+func (c *JokeClient) Categories(ctx context.Context) (categories []string, err error) {
+	done, err := c.Breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { done(upstreamResult(err)) }()
+	start := time.Now()
+	defer func() { metric.ObserveUpstream(metric.UpstreamJokes, start, err) }()
+
+	apiUrl := c.CategoriesUrl.String()
+	req, err := http.NewRequestWithContext(ctx, "GET", apiUrl, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create new http request with URL '%s'", apiUrl)
+	}
+	req.Header.Set("Accept", "application/json")
+	forwardRequestID(req)
+	resp, err := c.Retry.Do(c.HttpClient, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get joke categories from '%s'", apiUrl)
+	}
+	defer resp.Body.Close()
+	if err := checkJokesApiStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Type  string   `json:"type"`
+		Value []string `json:"value"`
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read jokes API response body")
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal jokes API categories response body")
+	}
+	if list.Type != "success" {
+		return nil, ErrUnsuccessfulJokeQuery
+	}
+	sort.Strings(list.Value)
+	return list.Value, nil
+}
+
+func (c JokeClient) jokesFromUrl(ctx context.Context, apiUrl string) (jokes []JokeValue, err error) {
+	done, err := c.Breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { done(upstreamResult(err)) }()
+	start := time.Now()
+	defer func() { metric.ObserveUpstream(metric.UpstreamJokes, start, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiUrl, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create new http request with URL '%s'", apiUrl)
+	}
+	req.Header.Set("Accept", "application/json")
+	forwardRequestID(req)
+	resp, err := c.Retry.Do(c.HttpClient, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get new jokes from '%s'", apiUrl)
+	}
+	defer resp.Body.Close()
+	if err := checkJokesApiStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var list jokeList
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read jokes API response body")
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal jokes API response body")
+	}
+	if list.Type != "success" {
+		return nil, ErrUnsuccessfulJokeQuery
+	}
+	for i := range list.Value {
+		list.Value[i].Joke = html.UnescapeString(list.Value[i].Joke)
+	}
+	return list.Value, nil
+}
+
+// checkJokesApiStatus returns an error if resp says the jokes API is rate limiting us or has failed.  Any other
+// response is left to its body, which says whether the request succeeded.
+func checkJokesApiStatus(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return withRetryAfter(ErrJokesApiTooManyRequests, resp)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return withRetryAfter(errors.Wrapf(ErrNon200JokeApiResponse, "status code %d", resp.StatusCode), resp)
+	}
+	return nil
+}
+
+// addParams will add the categories to include and exclude as parameters to url.
+func addParams(baseUrl url.URL, filter CategoryFilter) string {
 	params := url.Values{}
-	params.Set("firstName", fName)
-	params.Set("lastName", lName)
-	params.Set("limitTo", fmt.Sprintf("[%s]", category))
+	if len(filter.Include) > 0 {
+		params.Set("limitTo", fmt.Sprintf("[%s]", strings.Join(filter.Include, ",")))
+	}
+	if len(filter.Exclude) > 0 {
+		params.Set("exclude", fmt.Sprintf("[%s]", strings.Join(filter.Exclude, ",")))
+	}
 	baseUrl.RawQuery = params.Encode()
 	return baseUrl.String()
 }
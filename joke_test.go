@@ -1,12 +1,15 @@
 package jokesontap
 
 import (
+	"context"
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestEncodingUrlParameters(t *testing.T) {
@@ -14,21 +17,22 @@ func TestEncodingUrlParameters(t *testing.T) {
 	assert := assert.New(t)
 
 	tests := []struct {
-		url      string
-		fName    string
-		lName    string
-		category string
-		expUrl   string
+		name   string
+		url    string
+		filter CategoryFilter
+		expUrl string
 	}{
-		{"http://x.y", "jason", "bourne", "nerdy", "http://x.y?firstName=jason&lastName=bourne&limitTo=%5Bnerdy%5D"},
-		{"http://y.z", "Barry", "Allen", "nerdy", "http://y.z?firstName=Barry&lastName=Allen&limitTo=%5Bnerdy%5D"},
+		{"single", "http://x.y", CategoryFilter{Include: []string{"nerdy"}}, "http://x.y?limitTo=%5Bnerdy%5D"},
+		{"several", "http://y.z", CategoryFilter{Include: []string{"nerdy", "explicit"}}, "http://y.z?limitTo=%5Bnerdy%2Cexplicit%5D"},
+		{"exclude", "http://y.z", CategoryFilter{Exclude: []string{"explicit"}}, "http://y.z?exclude=%5Bexplicit%5D"},
+		{"none", "http://y.z", CategoryFilter{}, "http://y.z"},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.fName+" "+tt.lName, func(t *testing.T) {
+		t.Run(tt.name, func(t *testing.T) {
 			u, err := url.Parse(tt.url)
 			assert.Nil(err)
-			assert.Equal(tt.expUrl, addParams(*u, tt.fName, tt.lName, "nerdy"))
+			assert.Equal(tt.expUrl, addParams(*u, tt.filter))
 		})
 	}
 }
@@ -59,7 +63,7 @@ func TestGettingJokesFromJokesClient(t *testing.T) {
 
 			// setting testing explicitly here ensures we can avoid transforming the URL, maintaining the raw
 			// URL from the test server
-			joke, err := jc.Joke()
+			joke, err := jc.Joke(context.Background())
 			assert.Nil(err)
 			assert.Equal(tt.joke, joke)
 		})
@@ -97,7 +101,7 @@ func TestJokesWithHtmlEntitiesAreProperlyConverted(t *testing.T) {
 			u, err := url.Parse(ts.URL)
 			assert.Nil(err)
 			jc := NewJokeClient(*u)
-			joke, err := jc.Joke()
+			joke, err := jc.Joke(context.Background())
 			assert.Nil(err)
 			assert.Equal(tt.expJoke, joke)
 		})
@@ -129,8 +133,58 @@ func TestInvalidJokesResponse(t *testing.T) {
 			u, err := url.Parse(ts.URL)
 			assert.Nil(err)
 			jc := NewJokeClient(*u)
-			_, err = jc.JokeWithCustomName("john", "smith")
+			_, err = jc.JokeWithCustomName(context.Background(), "john", "smith", CategoryFilter{})
 			assert.Contains(err.Error(), tt.expErrContains)
 		})
 	}
 }
+
+func TestJokeWithCustomNameSubstitutesLocally(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var query url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		_, err := fmt.Fprint(w, `{"type": "success", "value": { "joke": "Chuck Norris&apos;s keyboard has no F1 key."}}`)
+		assert.Nil(err)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	jc := NewJokeClient(*u)
+	joke, err := jc.JokeWithCustomName(context.Background(), "Ada", "Lovelace", CategoryFilter{})
+	assert.Nil(err)
+	assert.Equal("Ada Lovelace's keyboard has no F1 key.", joke.Joke)
+	// the upstream should never be asked to do the templating
+	assert.Empty(query.Get("firstName"))
+	assert.Empty(query.Get("lastName"))
+}
+
+func TestJokeRequestIsAbandonedWhenContextIsDone(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// hold the request open until the test is finished
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	jc := NewJokeClient(*u)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	start := time.Now()
+	_, err = jc.JokeWithCustomName(ctx, "john", "smith", CategoryFilter{})
+	assert.NotNil(err)
+	assert.Equal(context.DeadlineExceeded, errors.Cause(err).(*url.Error).Err)
+	// well short of the client timeout
+	assert.True(time.Since(start) < time.Second)
+}
@@ -0,0 +1,127 @@
+package jokesontap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// jokesByIDApi serves jokes by ID from the jokes API, where only jokes 1 and 2 exist.
+func jokesByIDApi() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/jokes/1":
+			fmt.Fprint(w, `{"type": "success", "value": {"id": 1, "joke": "Chuck Norris &amp; friends.", "categories": ["nerdy"]}}`)
+		case "/jokes/2":
+			fmt.Fprint(w, `{"type": "success", "value": {"id": 2, "joke": "Nobody is named here.", "categories": []}}`)
+		default:
+			fmt.Fprint(w, `{"type": "NoSuchQuoteException", "value": "No quote with id=3."}`)
+		}
+	}))
+}
+
+func TestJokeClientGetsJokeByID(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := jokesByIDApi()
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/jokes/random")
+	assert.Nil(err)
+	jc := NewJokeClient(*u)
+
+	joke, err := jc.JokeByID(context.Background(), 1)
+	assert.Nil(err)
+	assert.Equal(JokeValue{ID: 1, Joke: "Chuck Norris & friends.", Categories: []string{"nerdy"}}, joke)
+
+	_, err = jc.JokeByID(context.Background(), 3)
+	assert.Equal(ErrJokeNotFound, err)
+}
+
+func TestServerServesJokeByID(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := jokesByIDApi()
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/jokes/random")
+	assert.Nil(err)
+
+	names := make(chan Name, 1)
+	names <- Name{"Bill", "Murray"}
+	srv := Server{JokeClient: NewJokeClient(*u), Names: names}
+
+	tests := []struct {
+		name      string
+		path      string
+		expStatus int
+		expBody   string
+	}{
+		{"random_name", "/jokes/1", http.StatusOK, "Bill Murray & friends.\n"},
+		{"caller_name", "/jokes/1?firstName=Ada&lastName=Lovelace", http.StatusOK, "Ada Lovelace & friends.\n"},
+		{"no_placeholder", "/jokes/2?firstName=Ada", http.StatusOK, "Nobody is named here.\n"},
+		{"unknown", "/jokes/3?firstName=Ada", http.StatusNotFound, CodeJokeNotFound + ": " + ErrJokeNotFound.Error() + "\n"},
+		{"not_a_number", "/jokes/abc", http.StatusNotFound, CodeJokeNotFound + ": " + ErrJokeNotFound.Error() + "\n"},
+		{"negative", "/jokes/-1", http.StatusNotFound, CodeJokeNotFound + ": " + ErrJokeNotFound.Error() + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://doesnt.matter"+tt.path, nil)
+			w := httptest.NewRecorder()
+			srv.GetJokeByID(w, req)
+			body, err := ioutil.ReadAll(w.Result().Body)
+			assert.Nil(err)
+			assert.Equal(tt.expStatus, w.Result().StatusCode)
+			assert.Equal(tt.expBody, string(body))
+		})
+	}
+}
+
+func TestServerSpendsNoNameOnJokeWithoutPlaceholder(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := jokesByIDApi()
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/jokes/random")
+	assert.Nil(err)
+
+	names := make(chan Name, 1)
+	names <- Name{"Bill", "Murray"}
+	srv := Server{JokeClient: NewJokeClient(*u), Names: names}
+
+	req := httptest.NewRequest("GET", "http://doesnt.matter/jokes/2", nil)
+	w := httptest.NewRecorder()
+	srv.GetJokeByID(w, req)
+	assert.Equal(http.StatusOK, w.Result().StatusCode)
+	assert.Equal(1, len(names))
+}
+
+func TestServerPrefersJokeStoreForJokeByID(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	srv := Server{JokeStore: categorizedStore(t), Names: make(chan Name)}
+	req := httptest.NewRequest("GET", "http://doesnt.matter/jokes/2?firstName=Ada&lastName=Lovelace", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	srv.GetJokeByID(w, req)
+	assert.Equal(http.StatusOK, w.Result().StatusCode)
+
+	var resp JokeResponse
+	assert.Nil(json.NewDecoder(w.Result().Body).Decode(&resp))
+	assert.Equal(JokeResponse{
+		Joke:       "Ada Lovelace is explicit.",
+		ID:         2,
+		FirstName:  "Ada",
+		LastName:   "Lovelace",
+		Categories: []string{"explicit"},
+	}, resp)
+}
@@ -1,47 +1,250 @@
 package jokesontap
 
 import (
+	"encoding/json"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
 var logTimestampFmt = time.RFC3339
 
+// addRequestIDHook adds the requestIDHook to the global logger only once, however many times it's initialized.
+var addRequestIDHook sync.Once
+
 // InitLogger sets values on the global logger for use everywhere else in the application.
 func InitLogger(w io.Writer, level string, format string, prettyJson bool) {
-	var lvl log.Level
+	lvl, err := parseLevel(level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	formatter, err := newFormatter(format, prettyJson)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetLevel(lvl)
+	log.SetFormatter(formatter)
+	log.SetOutput(w)
+	log.SetReportCaller(true)
+	addRequestIDHook.Do(func() { log.AddHook(requestIDHook{}) })
+}
+
+func parseLevel(level string) (log.Level, error) {
 	switch strings.ToLower(level) {
 	case "trace":
-		lvl = log.TraceLevel
+		return log.TraceLevel, nil
 	case "debug":
-		lvl = log.DebugLevel
+		return log.DebugLevel, nil
 	case "info":
-		lvl = log.InfoLevel
+		return log.InfoLevel, nil
 	case "warn":
-		lvl = log.WarnLevel
+		return log.WarnLevel, nil
 	case "error":
-		lvl = log.ErrorLevel
+		return log.ErrorLevel, nil
 	case "fatal":
-		lvl = log.FatalLevel
+		return log.FatalLevel, nil
 	default:
-		log.Fatalf("unexpected level '%s'", level)
+		return 0, fmt.Errorf("unexpected level '%s'", level)
 	}
+}
 
-	var formatter log.Formatter
+func newFormatter(format string, prettyJson bool) (log.Formatter, error) {
 	switch strings.ToLower(format) {
 	case "json":
-		formatter = &log.JSONFormatter{TimestampFormat: logTimestampFmt, PrettyPrint: prettyJson}
+		return &log.JSONFormatter{TimestampFormat: logTimestampFmt, PrettyPrint: prettyJson}, nil
 	case "text":
-		formatter = &log.TextFormatter{TimestampFormat: logTimestampFmt, FullTimestamp: true}
+		return &log.TextFormatter{TimestampFormat: logTimestampFmt, FullTimestamp: true}, nil
 	default:
-		formatter = &log.TextFormatter{TimestampFormat: logTimestampFmt, FullTimestamp: true}
-		log.Fatalf("unexpected format '%s'", format)
+		return nil, fmt.Errorf("unexpected format '%s'", format)
+	}
+}
+
+// LogSwitch changes the level and format of the global logger while the server is running, optionally reverting
+// them to the configured level and format after a while.  It is safe for concurrent use.
+type LogSwitch struct {
+	// Level and Format are the configured level and format, which the logger is reverted to.
+	Level      string
+	Format     string
+	PrettyJson bool
+	// RevertAfter is how long a change lasts when none is given, or 0 for changes to last until reverted.
+	RevertAfter time.Duration
+
+	mu       sync.Mutex
+	level    string
+	format   string
+	revertAt time.Time
+	revert   *time.Timer
+}
 
+// NewLogSwitch creates a LogSwitch for a logger initialized with level and format.
+func NewLogSwitch(level, format string, prettyJson bool) *LogSwitch {
+	return &LogSwitch{Level: level, Format: format, PrettyJson: prettyJson}
+}
+
+// LogState is the level and format of the global logger.
+type LogState struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+	// RevertAt is when the level and format will be reverted to the configured ones, if they will be.
+	RevertAt *time.Time `json:"revertAt,omitempty"`
+}
+
+// State gets the current level and format.
+func (l *LogSwitch) State() LogState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state()
+}
+
+// Set changes the level and format, where an empty level or format is left as it is.  The change is reverted after
+// revertAfter, when positive.
+func (l *LogSwitch) Set(level, format string, revertAfter time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := l.state()
+	if level == "" {
+		level = current.Level
+	}
+	if format == "" {
+		format = current.Format
+	}
+	if err := l.apply(level, format); err != nil {
+		return err
+	}
+
+	if l.revert != nil {
+		l.revert.Stop()
+		l.revert = nil
+	}
+	l.revertAt = time.Time{}
+	if revertAfter > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(revertAfter, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			// a later change may have replaced this one while the timer fired
+			if l.revert == timer {
+				l.revertLocked()
+			}
+		})
+		l.revertAt = time.Now().Add(revertAfter)
+		l.revert = timer
+	}
+	log.WithFields(log.Fields{"level": level, "format": format, "revertAfter": revertAfter}).Warn("log settings changed")
+	return nil
+}
+
+// Revert changes the level and format back to the configured ones.
+func (l *LogSwitch) Revert() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.revertLocked()
+}
+
+// revertLocked changes the level and format back to the configured ones.  The caller must hold the lock.
+func (l *LogSwitch) revertLocked() {
+	if l.revert != nil {
+		l.revert.Stop()
+		l.revert = nil
+	}
+	l.revertAt = time.Time{}
+	if err := l.apply(l.Level, l.Format); err != nil {
+		log.WithError(err).Error("unable to revert log settings")
+		return
+	}
+	log.WithFields(log.Fields{"level": l.Level, "format": l.Format}).Warn("log settings reverted")
+}
+
+// ToggleLevel switches between the trace level and the configured level, reverting after RevertAfter.
+func (l *LogSwitch) ToggleLevel() {
+	level := "trace"
+	if l.State().Level == "trace" {
+		level = l.Level
+	}
+	if err := l.Set(level, "", l.RevertAfter); err != nil {
+		log.WithError(err).Error("unable to change log level")
+	}
+}
+
+// ToggleFormat switches between the text and json formats, reverting after RevertAfter.
+func (l *LogSwitch) ToggleFormat() {
+	format := "json"
+	if l.State().Format == "json" {
+		format = "text"
+	}
+	if err := l.Set("", format, l.RevertAfter); err != nil {
+		log.WithError(err).Error("unable to change log format")
+	}
+}
+
+// ServeHTTP serves the current level and format as JSON.  PUT or POST changes them with the level, format and
+// revertAfter parameters, where revertAfter is a duration like 10m.  Without revertAfter the change is reverted after
+// RevertAfter, and a revertAfter of 0 keeps the change until it is reverted.  DELETE reverts to the configured level
+// and format.
+func (l *LogSwitch) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut, http.MethodPost:
+		revertAfter := l.RevertAfter
+		if raw := req.FormValue("revertAfter"); raw != "" {
+			var err error
+			if revertAfter, err = time.ParseDuration(raw); err != nil || revertAfter < 0 {
+				http.Error(w, fmt.Sprintf("invalid revertAfter '%s', should be a duration like 10m", raw), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := l.Set(req.FormValue("level"), req.FormValue("format"), revertAfter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		l.Revert()
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJson)
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(l.State()); err != nil {
+		log.WithContext(req.Context()).WithError(err).Error("unable to write log settings response")
+	}
+}
+
+// apply sets level and format on the global logger.  The caller must hold the lock.
+func (l *LogSwitch) apply(level, format string) error {
+	lvl, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+	formatter, err := newFormatter(format, l.PrettyJson)
+	if err != nil {
+		return err
 	}
 	log.SetLevel(lvl)
 	log.SetFormatter(formatter)
-	log.SetOutput(w)
-	log.SetReportCaller(true)
+	l.level = strings.ToLower(level)
+	l.format = strings.ToLower(format)
+	return nil
+}
+
+// state gets the current level and format.  The caller must hold the lock.
+func (l *LogSwitch) state() LogState {
+	s := LogState{Level: l.level, Format: l.format}
+	if s.Level == "" {
+		s.Level = strings.ToLower(l.Level)
+	}
+	if s.Format == "" {
+		s.Format = strings.ToLower(l.Format)
+	}
+	if !l.revertAt.IsZero() {
+		revertAt := l.revertAt
+		s.RevertAt = &revertAt
+	}
+	return s
 }
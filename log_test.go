@@ -0,0 +1,120 @@
+package jokesontap
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// restoreLogger puts back the level and formatter of the global logger once a test which changes them is done.
+func restoreLogger() func() {
+	level, formatter := log.GetLevel(), log.StandardLogger().Formatter
+	return func() {
+		log.SetLevel(level)
+		log.SetFormatter(formatter)
+	}
+}
+
+func TestLogSwitchChangesAndReverts(t *testing.T) {
+	defer restoreLogger()()
+	assert := assert.New(t)
+
+	l := NewLogSwitch("info", "text", false)
+	assert.Nil(l.Set("debug", "", 0))
+	assert.Equal(log.DebugLevel, log.GetLevel())
+	assert.Equal(LogState{Level: "debug", Format: "text"}, l.State())
+
+	assert.Nil(l.Set("", "json", 20*time.Millisecond))
+	assert.Equal(log.DebugLevel, log.GetLevel())
+	assert.IsType(&log.JSONFormatter{}, log.StandardLogger().Formatter)
+	assert.NotNil(l.State().RevertAt)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(LogState{Level: "info", Format: "text"}, l.State())
+	assert.Equal(log.InfoLevel, log.GetLevel())
+	assert.IsType(&log.TextFormatter{}, log.StandardLogger().Formatter)
+
+	assert.NotNil(l.Set("loud", "", 0))
+	assert.Equal(log.InfoLevel, log.GetLevel())
+}
+
+func TestLogSwitchLaterChangeOutlastsEarlierRevert(t *testing.T) {
+	defer restoreLogger()()
+	assert := assert.New(t)
+
+	l := NewLogSwitch("info", "text", false)
+	assert.Nil(l.Set("debug", "", 10*time.Millisecond))
+	assert.Nil(l.Set("trace", "", 0))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(log.TraceLevel, log.GetLevel())
+}
+
+func TestLogSwitchToggles(t *testing.T) {
+	defer restoreLogger()()
+	assert := assert.New(t)
+
+	l := NewLogSwitch("warn", "text", false)
+	l.ToggleLevel()
+	assert.Equal("trace", l.State().Level)
+	l.ToggleLevel()
+	assert.Equal("warn", l.State().Level)
+
+	l.ToggleFormat()
+	assert.Equal("json", l.State().Format)
+	l.ToggleFormat()
+	assert.Equal("text", l.State().Format)
+}
+
+func TestLogSwitchServesChanges(t *testing.T) {
+	defer restoreLogger()()
+
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		expStatus int
+		expLevel  string
+	}{
+		{"get", "GET", "/debug/log", http.StatusOK, "info"},
+		{"set", "PUT", "/debug/log?level=trace&revertAfter=1h", http.StatusOK, "trace"},
+		{"invalid_level", "PUT", "/debug/log?level=loud", http.StatusBadRequest, "trace"},
+		{"invalid_revert", "PUT", "/debug/log?level=debug&revertAfter=soon", http.StatusBadRequest, "trace"},
+		{"revert", "DELETE", "/debug/log", http.StatusOK, "info"},
+		{"not_allowed", "PATCH", "/debug/log", http.StatusMethodNotAllowed, "info"},
+	}
+
+	// each request builds on the last
+	l := NewLogSwitch("info", "text", false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			w := httptest.NewRecorder()
+			l.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			assert.Equal(tt.expStatus, w.Code)
+			assert.Equal(tt.expLevel, l.State().Level)
+			if w.Code == http.StatusOK {
+				var state LogState
+				assert.Nil(json.NewDecoder(w.Body).Decode(&state))
+				assert.Equal(tt.expLevel, state.Level)
+			}
+		})
+	}
+}
+
+func TestLogSwitchAcceptsFormBodies(t *testing.T) {
+	defer restoreLogger()()
+	assert := assert.New(t)
+
+	l := NewLogSwitch("info", "text", false)
+	req := httptest.NewRequest("POST", "/debug/log", strings.NewReader("level=debug&format=json&revertAfter=0"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(LogState{Level: "debug", Format: "json"}, l.State())
+}
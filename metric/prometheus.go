@@ -6,40 +6,187 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"sync"
+	"time"
 )
 
-var MHttpRequestTotal *prometheus.CounterVec
+const (
+	// UpstreamJokes labels metrics for requests to the jokes API.
+	UpstreamJokes = "jokes"
+	// UpstreamNames labels metrics for requests to the names API.
+	UpstreamNames = "names"
+)
+
+// Metrics are created up front so they can be safely used anywhere in the application, whether or not
+// the Prometheus server has been started.
+var (
+	MHttpRequestTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_request_total",
+			Help: "Total number of http requests to the server.",
+		},
+		[]string{"route", "statuscode"},
+	)
+	MHttpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of http requests to the server.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"route", "statuscode"},
+	)
+	MUpstreamRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "upstream_request_duration_seconds",
+			Help:    "Latency of requests to upstream APIs, successful or not.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"upstream"},
+	)
+	MUpstreamErrorTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upstream_error_total",
+			Help: "Total number of failed requests to upstream APIs.",
+		},
+		[]string{"upstream"},
+	)
+	MNamesChanLength = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "names_chan_length",
+			Help: "Number of names waiting in the names channel.",
+		},
+	)
+	MNamesChanCapacity = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "names_chan_capacity",
+			Help: "Number of names the names channel can hold.",
+		},
+	)
+	MNamesBudgetWaitTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "names_budget_wait_total",
+			Help: "Total number of times a names API request had to wait for the request budget.",
+		},
+	)
+	MNamesTooManyRequestsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "names_too_many_requests_total",
+			Help: "Total number of 429 Too Many Requests responses from the names API.",
+		},
+	)
+	MNameProviderRequestTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "name_provider_request_total",
+			Help: "Total number of requests to each name provider by result.",
+		},
+		[]string{"provider", "result"},
+	)
+	MNameProviderHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "name_provider_healthy",
+			Help: "Whether each name provider is healthy (1) or cooling down after a failure (0).",
+		},
+		[]string{"provider"},
+	)
+	MCircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "State of each circuit breaker around an upstream API, closed (0), half-open (1) or open (2).",
+		},
+		[]string{"breaker"},
+	)
+	MJokesCorpusSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jokes_corpus_size",
+			Help: "Number of jokes in the corpus held by the server.",
+		},
+	)
+	MJokesCorpusUpdated = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jokes_corpus_updated_timestamp_seconds",
+			Help: "Unix time the jokes corpus was last successfully refreshed.",
+		},
+	)
+)
+
+// registerOnce ensures metrics are only registered once, no matter how many times the server is started.
+var registerOnce sync.Once
 
 type Prometheus struct {
 	// Port is the network port where prometheus will serve an endpoint
 	Port int
-	// set true when system is under test
-	test bool
 }
 
-// TODO: this doesn't work, at least not in basic testing.  We need to get a working Prometheus server, write some
-// TODO: tests and add more metrics throughout the application.
-// Run starts the Prometheus metrics server. Set metrics with the PrometheusMetrics.
+// Run starts the Prometheus metrics server in the background. Set metrics with the PrometheusMetrics.
 func (p Prometheus) Run() {
-	p.registerAllMetrics()
-
 	path := "/metrics"
 	log.Infof("starting prometheus at path '%s' on port '%d'", path, p.Port)
-	if !p.test {
-		mux := http.NewServeMux()
-		mux.Handle(path, promhttp.Handler())
-		go http.ListenAndServe(fmt.Sprintf(":%d", p.Port), promhttp.Handler())
-	}
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", p.Port), p.Handler(path)); err != nil {
+			log.WithError(err).Error("prometheus server stopped")
+		}
+	}()
+}
+
+// Handler registers all metrics and returns a handler which serves them at path.
+func (p Prometheus) Handler(path string) http.Handler {
+	registerOnce.Do(p.registerAllMetrics)
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.Handler())
+	return mux
 }
 
-// registerAllMetrics ensures all custom metrics are individually defined and registered with Prometheus.
+// registerAllMetrics ensures all custom metrics are individually registered with Prometheus.
 func (p Prometheus) registerAllMetrics() {
-	MHttpRequestTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "http_request_total",
-			Help: "Total number of http requests to the server.",
-		},
-		[]string{"statuscode"},
+	prometheus.MustRegister(
+		MHttpRequestTotal,
+		MHttpRequestDuration,
+		MUpstreamRequestDuration,
+		MUpstreamErrorTotal,
+		MNamesChanLength,
+		MNamesChanCapacity,
+		MNamesBudgetWaitTotal,
+		MNamesTooManyRequestsTotal,
+		MNameProviderRequestTotal,
+		MNameProviderHealthy,
+		MCircuitBreakerState,
+		MJokesCorpusSize,
+		MJokesCorpusUpdated,
 	)
-	prometheus.MustRegister(MHttpRequestTotal)
+}
+
+// ObserveUpstream records the latency of a request to upstream which began at start, counting the request as
+// an error if err is not nil.
+func ObserveUpstream(upstream string, start time.Time, err error) {
+	MUpstreamRequestDuration.WithLabelValues(upstream).Observe(time.Since(start).Seconds())
+	if err != nil {
+		MUpstreamErrorTotal.WithLabelValues(upstream).Inc()
+	}
+}
+
+// ObserveNamesChan records the length and capacity of the names channel.
+func ObserveNamesChan(length, capacity int) {
+	MNamesChanLength.Set(float64(length))
+	MNamesChanCapacity.Set(float64(capacity))
+}
+
+// ObserveNameProvider records the result of a request to a name provider and whether the provider is healthy.
+func ObserveNameProvider(provider, result string, healthy bool) {
+	MNameProviderRequestTotal.WithLabelValues(provider, result).Inc()
+	if healthy {
+		MNameProviderHealthy.WithLabelValues(provider).Set(1)
+	} else {
+		MNameProviderHealthy.WithLabelValues(provider).Set(0)
+	}
+}
+
+// ObserveJokesCorpus records the size of the jokes corpus and when it was last refreshed.
+func ObserveJokesCorpus(size int, updated time.Time) {
+	MJokesCorpusSize.Set(float64(size))
+	MJokesCorpusUpdated.Set(float64(updated.UnixNano()) / float64(time.Second))
+}
+
+// ObserveBreaker records the state of a circuit breaker, closed (0), half-open (1) or open (2).
+func ObserveBreaker(name string, state int) {
+	MCircuitBreakerState.WithLabelValues(name).Set(float64(state))
 }
@@ -0,0 +1,74 @@
+package metric
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// scrape gets the current metrics exposition from the Prometheus handler.
+func scrape(t *testing.T) string {
+	ts := httptest.NewServer(Prometheus{}.Handler("/metrics"))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestScrapingMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	MHttpRequestTotal.WithLabelValues("/", "200").Inc()
+	MHttpRequestDuration.WithLabelValues("/", "200").Observe(0.1)
+	ObserveUpstream(UpstreamJokes, time.Now(), nil)
+	ObserveUpstream(UpstreamNames, time.Now(), errors.New("failed"))
+	ObserveNamesChan(5, 10)
+	MNamesBudgetWaitTotal.Inc()
+	MNamesTooManyRequestsTotal.Inc()
+	ObserveNameProvider("backup", "error", false)
+	ObserveJokesCorpus(42, time.Unix(1500000000, 0))
+
+	body := scrape(t)
+	tests := []string{
+		`http_request_total{route="/",statuscode="200"} 1`,
+		`http_request_duration_seconds_count{route="/",statuscode="200"} 1`,
+		`upstream_request_duration_seconds_count{upstream="jokes"} 1`,
+		`upstream_request_duration_seconds_count{upstream="names"} 1`,
+		`upstream_error_total{upstream="names"} 1`,
+		`names_chan_length 5`,
+		`names_chan_capacity 10`,
+		`names_budget_wait_total 1`,
+		`names_too_many_requests_total 1`,
+		`name_provider_request_total{provider="backup",result="error"} 1`,
+		`name_provider_healthy{provider="backup"} 0`,
+		`jokes_corpus_size 42`,
+		`jokes_corpus_updated_timestamp_seconds 1.5e+09`,
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			assert.Contains(body, tt)
+		})
+	}
+	assert.NotContains(body, `upstream_error_total{upstream="jokes"}`)
+}
+
+func TestHandlerCanBeCreatedMoreThanOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	// metrics may only be registered once, which would panic if not handled
+	assert.NotPanics(func() {
+		Prometheus{}.Handler("/metrics")
+		Prometheus{}.Handler("/metrics")
+	})
+}
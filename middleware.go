@@ -0,0 +1,237 @@
+package jokesontap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/swtch1/jokesontap/metric"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestIDHeader identifies a request across the services which handle it.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID matches request IDs which are safe to log and echo back to the client.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// statusWriter is a http.ResponseWriter which keeps track of the status code and number of bytes written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Status is the status code written to the response, which is 200 if nothing has been written yet.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// instrument records request count and latency metrics for every request handled by next under route.
+func instrument(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next(sw, req)
+
+		code := strconv.Itoa(sw.Status())
+		metric.MHttpRequestTotal.WithLabelValues(route, code).Inc()
+		metric.MHttpRequestDuration.WithLabelValues(route, code).Observe(time.Since(start).Seconds())
+	}
+}
+
+// withRequestID gives every request handled by next an ID, taken from the X-Request-ID request header when it is
+// valid or generated otherwise.  The ID is echoed in the X-Request-ID response header and stored in the request
+// context, where it is added to every log entry made with the context.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestID gets the ID of the request being handled with ctx, or an empty string outside of a request.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// IDs only need to be unique enough to find a request in the logs
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDHook adds the request ID to log entries made with the context of a request, as in
+// log.WithContext(req.Context()).
+type requestIDHook struct{}
+
+func (requestIDHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (requestIDHook) Fire(entry *log.Entry) error {
+	if id := RequestID(entry.Context); id != "" {
+		entry.Data["requestId"] = id
+	}
+	return nil
+}
+
+// Access log formats.
+const (
+	AccessLogJson     = "json"
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+)
+
+// clfTimestampFmt is the timestamp format of the Common Log Format.
+const clfTimestampFmt = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogger writes a line for every request handled by the server.
+type AccessLogger struct {
+	// Format is one of json, common or combined.  Lines in the Common and Combined Log Formats end with the request
+	// latency in seconds and the request ID.
+	Format string
+	Out    io.Writer
+
+	// mu keeps lines from interleaving.
+	mu sync.Mutex
+}
+
+// NewAccessLogger creates an AccessLogger which writes lines in format to out.
+func NewAccessLogger(out io.Writer, format string) (*AccessLogger, error) {
+	switch format {
+	case AccessLogJson, AccessLogCommon, AccessLogCombined:
+	default:
+		return nil, fmt.Errorf("unsupported access log format '%s', format should be one of %s, %s, %s", format, AccessLogJson, AccessLogCommon, AccessLogCombined)
+	}
+	return &AccessLogger{Format: format, Out: out}, nil
+}
+
+// accessLogEntry is a single line of the access log, as written in JSON.
+type accessLogEntry struct {
+	Time      string  `json:"time"`
+	RequestID string  `json:"requestId"`
+	ClientIP  string  `json:"clientIp"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Bytes     int     `json:"bytes"`
+	Latency   float64 `json:"latencySeconds"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"userAgent,omitempty"`
+}
+
+// Handler writes an access log line for every request handled by next.  A nil AccessLogger writes nothing.
+func (a *AccessLogger) Handler(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, req)
+		a.write(accessLogEntry{
+			Time:      start.Format(logTimestampFmt),
+			RequestID: RequestID(req.Context()),
+			ClientIP:  clientIP(req),
+			Method:    req.Method,
+			Path:      req.URL.RequestURI(),
+			Proto:     req.Proto,
+			Status:    sw.Status(),
+			Bytes:     sw.bytes,
+			Latency:   time.Since(start).Seconds(),
+			Referer:   req.Referer(),
+			UserAgent: req.UserAgent(),
+		}, start)
+	})
+}
+
+func (a *AccessLogger) write(e accessLogEntry, start time.Time) {
+	var line []byte
+	switch a.Format {
+	case AccessLogJson:
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.WithError(err).Error("unable to write access log")
+			return
+		}
+		line = append(b, '\n')
+	default:
+		// ref: https://httpd.apache.org/docs/current/logs.html#common
+		bytes := "-"
+		if e.Bytes > 0 {
+			bytes = strconv.Itoa(e.Bytes)
+		}
+		clf := fmt.Sprintf("%s - - [%s] %s %d %s", e.ClientIP, start.Format(clfTimestampFmt),
+			strconv.Quote(e.Method+" "+e.Path+" "+e.Proto), e.Status, bytes)
+		if a.Format == AccessLogCombined {
+			clf += fmt.Sprintf(" %s %s", quoteOrDash(e.Referer), quoteOrDash(e.UserAgent))
+		}
+		line = []byte(fmt.Sprintf("%s %.6f %s\n", clf, e.Latency, quoteOrDash(e.RequestID)))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.Out.Write(line); err != nil {
+		log.WithError(err).Error("unable to write access log")
+	}
+}
+
+func quoteOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strconv.Quote(s)
+}
+
+// clientIP gets the IP address of the client which made req.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return strings.TrimSpace(req.RemoteAddr)
+	}
+	return host
+}
@@ -0,0 +1,158 @@
+package jokesontap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func TestRequestIDIsPropagatedOrGenerated(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		incoming string
+		expKept  bool
+	}{
+		{"propagated", "abc-123.def:4_5", true},
+		{"generated", "", false},
+		{"unsafe", "bad id\n", false},
+		{"too_long", string(bytes.Repeat([]byte("a"), 129)), false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+
+			var seen string
+			h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestID(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			assert.NotEmpty(seen)
+			assert.Equal(seen, w.Header().Get(requestIDHeader))
+			if tt.expKept {
+				assert.Equal(tt.incoming, seen)
+			} else {
+				assert.Regexp(regexp.MustCompile(`^[0-9a-f]{32}$`), seen)
+			}
+		})
+	}
+}
+
+func TestRequestIDIsLoggedAndForwarded(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var forwarded string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(requestIDHeader)
+		fmt.Fprint(w, `{"type": "success", "value": {"id": 1, "joke": "Chuck Norris can compile syntax errors."}}`)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+
+	var logs bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&logs)
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.SetLevel(log.TraceLevel)
+	logger.AddHook(requestIDHook{})
+
+	srv := Server{JokeClient: NewJokeClient(*u)}
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.WithContext(r.Context()).Info("handling request")
+		srv.GetCustomJoke(w, r)
+	}))
+	req := httptest.NewRequest("GET", "/?firstName=Bill&lastName=Gates", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("req-1", forwarded)
+	var entry map[string]interface{}
+	assert.Nil(json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal("req-1", entry["requestId"])
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format  string
+		expLine *regexp.Regexp
+	}{
+		{AccessLogCommon, regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET /jokes\?count=2 HTTP/1\.1" 418 5 \d+\.\d{6} "req-1"\n$`)},
+		{AccessLogCombined, regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET /jokes\?count=2 HTTP/1\.1" 418 5 "http://example\.com" "curl/7\.0" \d+\.\d{6} "req-1"\n$`)},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.format, func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+			var out bytes.Buffer
+			al, err := NewAccessLogger(&out, tt.format)
+			assert.Nil(err)
+
+			serveTeapot(al)
+			assert.Regexp(tt.expLine, out.String())
+		})
+	}
+
+	t.Run(AccessLogJson, func(t *testing.T) {
+		t.Parallel()
+		assert := assert.New(t)
+		var out bytes.Buffer
+		al, err := NewAccessLogger(&out, AccessLogJson)
+		assert.Nil(err)
+
+		serveTeapot(al)
+		var entry accessLogEntry
+		assert.Nil(json.Unmarshal(out.Bytes(), &entry))
+		assert.Equal("req-1", entry.RequestID)
+		assert.Equal("192.0.2.1", entry.ClientIP)
+		assert.Equal("GET", entry.Method)
+		assert.Equal("/jokes?count=2", entry.Path)
+		assert.Equal(http.StatusTeapot, entry.Status)
+		assert.Equal(5, entry.Bytes)
+		assert.True(entry.Latency >= 0)
+		assert.Equal("curl/7.0", entry.UserAgent)
+	})
+}
+
+// serveTeapot serves a single request through al and withRequestID.
+func serveTeapot(al *AccessLogger) {
+	h := withRequestID(al.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprint(w, "short")
+	})))
+	req := httptest.NewRequest("GET", "/jokes?count=2", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	req.Header.Set("Referer", "http://example.com")
+	req.Header.Set("User-Agent", "curl/7.0")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestAccessLogRejectsUnknownFormats(t *testing.T) {
+	t.Parallel()
+	_, err := NewAccessLogger(&bytes.Buffer{}, "apache")
+	assert.NotNil(t, err)
+}
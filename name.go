@@ -1,12 +1,18 @@
 package jokesontap
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/swtch1/jokesontap/breaker"
+	"github.com/swtch1/jokesontap/budget"
+	"github.com/swtch1/jokesontap/metric"
+	"github.com/swtch1/jokesontap/retry"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 )
 
@@ -16,12 +22,6 @@ var (
 	ErrNamesApiTooManyRequests = errors.New("too many requests to names API")
 )
 
-// TODO: complete implementation of this interface
-//// Cacher represents a cache.
-//type Cacher interface {
-//	Add(interface{}, interface{}) bool
-//}
-
 type Name struct {
 	Name    string `json:"name"`
 	Surname string `json:"surname"`
@@ -33,42 +33,47 @@ type NameClient struct {
 	ApiUrl url.URL
 	// HttpClient is a http client which can be reused across multiple requests.
 	HttpClient *http.Client
-	//// Cache holds names that we may want to use if the external API is unavailable.
-	//Cache Cacher
-
-	// TODO: this can be removed when proper metrics are implemented
-	// nameReqRate is the per-minute rate at which the name client is being requested.
-	nameReqRate float64 // FIXME: testing
+	// Retry retries requests which fail for reasons likely to pass.  A 429 is never retried, as the retry would not
+	// be counted against the budget which keeps us within the names API's rate limit.
+	Retry retry.Policy
+	// Breaker, when set, fails requests at once while the names API keeps failing.
+	Breaker *breaker.Breaker
+	// Cache, when set, holds every name got from the names API, keyed by name, so names can still be drawn with
+	// CachedName while the names API is unavailable.
+	Cache Cacher
 }
 
 // NewNameClient creates a NameClient with default values where baseUrl is the API URL to query.
 func NewNameClient(baseUrl url.URL) *NameClient {
-	// TODO: the client values here _may_ be too detailed for the command line, but could be taken in thorough a more
-	// TODO: detailed config file or env vars.  just would be better to be able to dynamically configure.
 	return &NameClient{
-		ApiUrl: baseUrl,
-		HttpClient: &http.Client{
-			Timeout: 5 * time.Second,
-			Transport: &http.Transport{
-				DisableCompression: true,
-				MaxIdleConns:       10,
-				IdleConnTimeout:    30 * time.Second,
-			},
-		},
+		ApiUrl:     baseUrl,
+		HttpClient: NewHttpClient(DefaultHttpClientOpts),
+		Retry:      DefaultRetryPolicy,
 	}
 }
 
 // Names gets several names from the names API.  Names is intelligent as it relates to the restrictions
 // of the name API and will short circuit if too many requests are made.  If Names is called more often
-// than the API will allow an ErrTooManyNameRequests error will be returned.
-func (c *NameClient) Names() ([]Name, error) {
-	req, err := http.NewRequest("GET", c.ApiUrl.String(), nil)
+// than the API will allow an ErrTooManyNameRequests error will be returned.  The request to the names API is
+// abandoned when ctx is done.
+func (c *NameClient) Names(ctx context.Context) (names []Name, err error) {
+	done, err := c.Breaker.Allow()
+	if err != nil {
+		return []Name{}, err
+	}
+	defer func() { done(upstreamResult(err)) }()
+	start := time.Now()
+	defer func() { metric.ObserveUpstream(metric.UpstreamNames, start, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.ApiUrl.String(), nil)
 	if err != nil {
 		return []Name{}, errors.Wrapf(err, "unable to create new http request with URL '%s'", c.ApiUrl.String())
 	}
 	req.Header.Set("Accept", "application/json")
 	log.Tracef("getting names from name server")
-	resp, err := c.HttpClient.Do(req)
+	policy := c.Retry
+	policy.SkipTooManyRequests = true
+	resp, err := policy.Do(c.HttpClient, req)
 	if err != nil {
 		return []Name{}, errors.Wrapf(err, "unable to get new name from '%s'", c.ApiUrl.String())
 	}
@@ -78,12 +83,11 @@ func (c *NameClient) Names() ([]Name, error) {
 	case http.StatusOK:
 		// do nothing
 	case http.StatusTooManyRequests:
-		return []Name{}, ErrNamesApiTooManyRequests
+		return []Name{}, withRetryAfter(ErrNamesApiTooManyRequests, resp)
 	default:
-		return []Name{}, errors.Wrapf(ErrNon200NameApiResponse, "status code %d", resp.StatusCode)
+		return []Name{}, withRetryAfter(errors.Wrapf(ErrNon200NameApiResponse, "status code %d", resp.StatusCode), resp)
 	}
 
-	var names []Name
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return []Name{}, errors.Wrapf(err, "unable to read names API response body")
@@ -91,104 +95,201 @@ func (c *NameClient) Names() ([]Name, error) {
 	if err := json.Unmarshal(body, &names); err != nil {
 		return []Name{}, errors.Wrap(err, "unable to unmarshal names API response body, possible rate limiting from name service")
 	}
+	if c.Cache != nil {
+		for _, name := range names {
+			c.Cache.Add(name, name)
+		}
+	}
 	return names, nil
 }
 
-//// CachedName gets a previously used name from the cache.
-//func (c *NameClient) CachedName() string {
-//	// TODO: implement a cache so we have the option to pull from cached names if we run out of unique names
-//	// TODO: this would also need to be taken as a flag, probably through a Cache-Control header in the request
-//	return ""
-//}
+// CachedName gets a random name previously got from the names API, if the Cache holds any.
+func (c *NameClient) CachedName() (Name, bool) {
+	if c.Cache == nil {
+		return Name{}, false
+	}
+	name, ok := c.Cache.Random()
+	if !ok {
+		return Name{}, false
+	}
+	return name.(Name), true
+}
 
-// TODO: this implementation is fairly specific to the problem it solves.  We could break this out to be a more general
-// TODO: budget executor, but probably not necessary until we have to use this pattern in more than one place.
 // BudgetNameReq is a budgeted names API requester which will make no more requests than the
 // external API will tolerate.
 type BudgetNameReq struct {
-	// requests keeps track of when requests were made.  The size of the array should be set to the maximum
-	// number of requests (the budget) that can be made within the MinDiff time.
-	//
-	// When creating a budget where x operations can be run in y time, the size of this array should
-	// be set as the x value.
-	requests [6]time.Time
-	// pos is the current position in requests, a tracker for getting the oldest names API request.
-	pos int
-	// MinDiff is the minimum amount of time between now and the oldest names API request allowed before
-	// we are "over budget", after which we cannot make any more requests.
-	//
-	// When creating a budget where x operations can be run in y time, this should be set as the y value.
-	MinDiff time.Duration
+	// Budget limits how often the names API is requested.  When nil the names API is requested as often as
+	// needed, which is only appropriate when NameClient limits itself, as NameProviders does.
+	Budget budget.Budget
 
 	// NameClient is used to request new names.
 	NameClient NameRequester
 	// NameChan is populated with the results of each names API request.
 	NameChan chan Name
+	// Pending, when set, is kept up to date with the names pushed to NameChan.
+	Pending *PendingNames
+
+	// initOnce lazily creates the signal channels so that a BudgetNameReq is usable without a constructor.
+	initOnce sync.Once
+	// wake signals that names have been taken from NameChan.
+	wake chan struct{}
+	// stop is closed when requesting should stop.
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// mu guards stats.
+	mu    sync.Mutex
+	stats NamesRequestStats
 }
 
-// RequestOften gets new names from the names API and pushes them to the names channel, as often as possible.
-// If the timestamp of the oldest call is more than MinDiff then we wait until we are with the budget to
-// make the next call.
-func (b *BudgetNameReq) RequestOften() {
-	var now time.Time
-	var diff time.Duration
+// NamesRequestStats describes the requests made by a BudgetNameReq.
+type NamesRequestStats struct {
+	Requests        int `json:"requests"`
+	TooManyRequests int `json:"tooManyRequests"`
+	// Names is the number of names fetched.
+	Names       int       `json:"names"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt"`
+}
 
-	for {
-		now = time.Now()
-		diff = now.Sub(b.oldestRequest())
+// tooManyRequestsWait is how long we back off from a names API which is rate limiting us without saying for how long.
+const tooManyRequestsWait = time.Second * 5
 
-		// wait until the time between now and the oldest request is within set bounds
-		for diff < b.MinDiff {
-			now = time.Now()
-			diff = now.Sub(b.oldestRequest())
+// fullRecheck is how often a full names channel is checked for room when nothing has signalled that names were
+// taken, which only matters when Wake is not being called.
+const fullRecheck = time.Second * 30
+
+// RequestOften gets new names from the names API and pushes them to the names channel, as often as possible,
+// until ctx is done or Stop is called.  If the budget does not allow another request then we sleep until it
+// does to make the next call.  While the names channel is full we sleep until Wake is called.
+func (b *BudgetNameReq) RequestOften(ctx context.Context) {
+	b.init()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-b.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		if ctx.Err() != nil {
+			log.Debug("stopping names requests")
+			return
 		}
 
 		nameChanFull := len(b.NameChan) == cap(b.NameChan)
 		if nameChanFull {
-			log.Trace("names channel is full, skipping attempt to get new names")
-			time.Sleep(time.Second * 1)
+			log.Trace("names channel is full, waiting for names to be taken")
+			timer := time.NewTimer(fullRecheck)
+			select {
+			case <-ctx.Done():
+			case <-b.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
 			continue
 		}
 
-		b.pushNamesFromAPI()
-		b.updateRequestTime(now)
+		if b.Budget != nil && !b.Budget.Allow() {
+			metric.MNamesBudgetWaitTotal.Inc()
+			log.WithField("next", b.Budget.Next()).Trace("names request budget spent, waiting")
+			if err := b.Budget.Wait(ctx); err != nil {
+				continue
+			}
+		}
+		b.pushNamesFromAPI(ctx)
+	}
+}
+
+// Wake signals that names have been taken from the names channel, waking RequestOften if it is waiting for room
+// in the channel.  Wake never blocks.
+func (b *BudgetNameReq) Wake() {
+	b.init()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+		// a wake up is already pending
 	}
 }
 
+// Stop stops RequestOften.  Stop may be called more than once.
+func (b *BudgetNameReq) Stop() {
+	b.init()
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
+func (b *BudgetNameReq) init() {
+	b.initOnce.Do(func() {
+		b.wake = make(chan struct{}, 1)
+		b.stop = make(chan struct{})
+	})
+}
+
 // pushNamesFromAPI pushes a new batch of names from into the name channel.
-func (b *BudgetNameReq) pushNamesFromAPI() {
-	names, err := b.NameClient.Names()
+func (b *BudgetNameReq) pushNamesFromAPI(ctx context.Context) {
+	names, err := b.NameClient.Names(ctx)
+	if ctx.Err() == nil {
+		// requests abandoned because we're stopping say nothing about the names API
+		b.record(len(names), err)
+	}
 	if err != nil {
+		log.WithError(err).Error("unable to get names from names client")
+		wait, ok := upstreamRetryAfter(err)
 		if errors.Cause(err) == ErrNamesApiTooManyRequests {
-			log.Debug("probable rate limiting in progress, back off querying names API")
-			time.Sleep(time.Second * 5)
+			metric.MNamesTooManyRequestsTotal.Inc()
+			if !ok {
+				wait, ok = tooManyRequestsWait, true
+			}
+		}
+		if ok {
+			log.WithField("wait", wait).Debug("names API asked us to back off, waiting before querying it again")
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+			case <-timer.C:
+			}
+			timer.Stop()
 		}
-		log.WithError(err).Error("unable to get names from names client")
 	}
 	for _, name := range names {
-		b.NameChan <- name
+		select {
+		case b.NameChan <- name:
+			b.Pending.Add(name)
+		case <-ctx.Done():
+			return
+		}
 	}
+	metric.ObserveNamesChan(len(b.NameChan), cap(b.NameChan))
 }
 
-func (b *BudgetNameReq) oldestRequest() time.Time {
-	return b.requests[b.pos]
+// Stats gets the stats of the requests made so far.
+func (b *BudgetNameReq) Stats() NamesRequestStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
 }
 
-func (b *BudgetNameReq) updateRequestTime(t time.Time) {
-	b.requests[b.pos] = t
-	b.incPos()
-}
-
-// incPos increases the position counter, dropping back to 0 when the
-// end of the requests tracking array is reached.
-func (b *BudgetNameReq) incPos() {
-	if b.pos >= len(b.requests)-1 {
-		b.pos = 0
-	} else {
-		b.pos++
+// record updates the stats after a request which got n names or failed with err.
+func (b *BudgetNameReq) record(n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Requests++
+	b.stats.Names += n
+	if err != nil {
+		b.stats.LastError = err.Error()
+		b.stats.LastErrorAt = time.Now()
+	}
+	if errors.Cause(err) == ErrNamesApiTooManyRequests {
+		b.stats.TooManyRequests++
 	}
 }
 
+// NameRequester can request a batch of random names, abandoning the request when ctx is done.
 type NameRequester interface {
-	Names() ([]Name, error)
+	Names(ctx context.Context) ([]Name, error)
 }
@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package jokesontap
+
+import (
+	"context"
+	"github.com/swtch1/jokesontap/budget"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// cpuTime is the total user and system CPU time used by this process.
+func cpuTime(b *testing.B) time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// BenchmarkRequestOftenIdle measures the CPU used while RequestOften has nothing to do, which should be close
+// to none.  Each op is a millisecond of idle time, so cpu-ns/op is the CPU used per millisecond idle.
+func BenchmarkRequestOftenIdle(b *testing.B) {
+	tests := []struct {
+		name string
+		nr   *BudgetNameReq
+	}{
+		{
+			"budget_spent",
+			&BudgetNameReq{
+				Budget:     budget.NewSlidingWindow(1, time.Hour),
+				NameClient: &MockNameClient{},
+				NameChan:   make(chan Name, 100),
+			},
+		},
+		{
+			"channel_full",
+			&BudgetNameReq{
+				Budget:     budget.NewSlidingWindow(100, time.Hour),
+				NameClient: &MockNameClient{},
+				NameChan:   make(chan Name, 2),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			go tt.nr.RequestOften(context.Background())
+			defer tt.nr.Stop()
+			// let the first batch of names be requested so we are idle from here on
+			time.Sleep(time.Millisecond * 10)
+
+			start := cpuTime(b)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				time.Sleep(time.Millisecond)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(cpuTime(b)-start)/float64(b.N), "cpu-ns/op")
+		})
+	}
+}
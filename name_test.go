@@ -1,11 +1,14 @@
 package jokesontap
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/swtch1/jokesontap/budget"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -52,7 +55,7 @@ func TestGettingNamesFromNamesClient(t *testing.T) {
 			u, err := url.Parse(ts.URL)
 			assert.Nil(err)
 			ns := NewNameClient(*u)
-			names, err := ns.Names()
+			names, err := ns.Names(context.Background())
 			assert.Nil(err)
 			for _, n := range tt.expNames {
 				assert.True(nameInNames(n, names))
@@ -72,7 +75,7 @@ func TestNewNameServerEnforcesTimeout(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		t.Run(string(tt.timeout), func(t *testing.T) {
+		t.Run(tt.timeout.String(), func(t *testing.T) {
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Content-Type", "application/json")
 				// sleep so that the client will timeout
@@ -87,7 +90,7 @@ func TestNewNameServerEnforcesTimeout(t *testing.T) {
 			ns := NewNameClient(*u)
 			// keep the timeout short so we force a timeout
 			ns.HttpClient.Timeout = tt.timeout
-			_, err = ns.Names()
+			_, err = ns.Names(context.Background())
 			assert.Contains(err.Error(), "Client.Timeout exceeded")
 		})
 	}
@@ -126,29 +129,24 @@ func TestInvalidNameResponse(t *testing.T) {
 			u, err := url.Parse(ts.URL)
 			assert.Nil(err)
 			nc := NewNameClient(*u)
-			_, err = nc.Names()
+			_, err = nc.Names(context.Background())
 			assert.Contains(err.Error(), tt.expErrContains)
 		})
 	}
 }
 
-func TestBudgetedNamesPositionNeverPanics(t *testing.T) {
-	t.Parallel()
-	assert := assert.New(t)
-
-	nr := BudgetNameReq{}
-	for i := 0; i < len(nr.requests)*2; i++ {
-		// basically just asserting that we aren't off by 1 which would eventually panic on an invalid index
-		assert.NotPanics(nr.incPos)
-	}
+type MockNameClient struct {
+	// NamesMethodCalls is updated atomically, as Names is called from the RequestOften goroutine.
+	NamesMethodCalls int32
 }
 
-type MockNameClient struct {
-	NamesMethodCalls int
+// calls gets the number of times Names has been called.
+func (c *MockNameClient) calls() int {
+	return int(atomic.LoadInt32(&c.NamesMethodCalls))
 }
 
-func (c *MockNameClient) Names() ([]Name, error) {
-	c.NamesMethodCalls++
+func (c *MockNameClient) Names(ctx context.Context) ([]Name, error) {
+	atomic.AddInt32(&c.NamesMethodCalls, 1)
 	return []Name{
 		{Name: "John", Surname: "Smith"},
 		{Name: "Steve", Surname: "Wilson"},
@@ -160,23 +158,99 @@ func TestBudgetedNamesExecutesAsOftenAsExpected(t *testing.T) {
 	assert := assert.New(t)
 	nc := &MockNameClient{}
 
-	// type takes into account array len so this will have to change if the implementation changes
-	const budget = 6
+	const ops = 6
 	const minDiff = time.Millisecond * 500
 
 	nChan := make(chan Name, 100)
 	nr := BudgetNameReq{
-		requests:   [budget]time.Time{},
-		MinDiff:    minDiff,
+		Budget:     budget.NewSlidingWindow(ops, minDiff),
 		NameClient: nc,
 		NameChan:   nChan,
 	}
 
-	go nr.RequestOften()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go nr.RequestOften(ctx)
 	// give the program ample time to loop and call the name client
 	time.Sleep(time.Millisecond * 250)
-	assert.Equal(budget, nc.NamesMethodCalls)
+	assert.Equal(ops, nc.calls())
 	// ensure we pass another cycle
 	time.Sleep(minDiff)
-	assert.Equal(budget*2, nc.NamesMethodCalls)
+	assert.Equal(ops*2, nc.calls())
+}
+
+func TestBudgetedNamesStopsWhenContextIsDone(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	nr := BudgetNameReq{
+		Budget:     budget.NewSlidingWindow(6, time.Hour),
+		NameClient: &MockNameClient{},
+		NameChan:   make(chan Name, 100),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		nr.RequestOften(ctx)
+		close(done)
+	}()
+
+	// let the budget run out so that we are waiting when cancelled
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail("names requests did not stop after context was cancelled")
+	}
+}
+
+func TestBudgetedNamesWakesWhenNamesAreTaken(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	nc := &MockNameClient{}
+	nr := BudgetNameReq{
+		Budget:     budget.NewSlidingWindow(100, time.Hour),
+		NameClient: nc,
+		NameChan:   make(chan Name, 2),
+	}
+	go nr.RequestOften(context.Background())
+	defer nr.Stop()
+
+	// the first batch fills the channel
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(1, nc.calls())
+
+	<-nr.NameChan
+	<-nr.NameChan
+	nr.Wake()
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(2, nc.calls())
+}
+
+func TestStoppingBudgetedNames(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	nr := BudgetNameReq{
+		Budget:     budget.NewSlidingWindow(1, time.Hour),
+		NameClient: &MockNameClient{},
+		NameChan:   make(chan Name, 100),
+	}
+	done := make(chan struct{})
+	go func() {
+		nr.RequestOften(context.Background())
+		close(done)
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	nr.Stop()
+	// stopping more than once is harmless
+	nr.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail("names requests did not stop")
+	}
 }
@@ -0,0 +1,293 @@
+package jokesontap
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/swtch1/jokesontap/breaker"
+	"github.com/swtch1/jokesontap/budget"
+	"github.com/swtch1/jokesontap/metric"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNoNameProviders = errors.New("no name providers are configured")
+
+// NameProvider is a single source of names used by NameProviders.
+type NameProvider struct {
+	// Name identifies the provider in logs, metrics and stats.
+	Name string
+	// Requester gets names from the provider.
+	Requester NameRequester
+	// Budget limits how often the provider is requested.  When nil the provider is requested as often as needed.
+	Budget budget.Budget
+	// Priority orders providers, those with a lower priority are always tried first.
+	Priority int
+	// Weight is how often the provider is tried first relative to other providers with the same priority.  A weight
+	// of 0 is treated as 1.
+	Weight int
+
+	// stats is guarded by the NameProviders mutex.
+	stats NameProviderStats
+}
+
+// NameProviderStats describes how a name provider has behaved.
+type NameProviderStats struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	Weight   int    `json:"weight"`
+	// Healthy is false while the provider is cooling down after a failed request.
+	Healthy bool `json:"healthy"`
+	// Breaker is the state of the provider's circuit breaker, if it has one.
+	Breaker         string    `json:"breaker,omitempty"`
+	Requests        int       `json:"requests"`
+	Failures        int       `json:"failures"`
+	TooManyRequests int       `json:"tooManyRequests"`
+	Names           int       `json:"names"`
+	LastError       string    `json:"lastError,omitempty"`
+	LastErrorAt     time.Time `json:"lastErrorAt"`
+	LastSuccess     time.Time `json:"lastSuccess"`
+	// RetryAt is when a provider which is cooling down will be tried again.
+	RetryAt time.Time `json:"retryAt"`
+	// Budget is the state of the provider's budget, if it has one.
+	Budget *BudgetStats `json:"budget,omitempty"`
+}
+
+// NameProviders is a NameRequester which combines several name providers, falling over to providers of a lower
+// priority only when those of a higher priority fail or are rate limited.  A provider which has only spent its budget
+// is waited for rather than fallen over from.  A provider which fails is left alone for a cool down period before it
+// is tried again.
+type NameProviders struct {
+	// Providers are the sources of names.
+	Providers []*NameProvider
+	// FailCooldown is how long a provider is left alone after a failed request, unless it asks for longer or shorter
+	// with Retry-After.
+	FailCooldown time.Duration
+	// TooManyRequestsCooldown is how long a provider is left alone after it responds that it is rate limiting us,
+	// unless it says how long with Retry-After.
+	TooManyRequestsCooldown time.Duration
+
+	mu sync.Mutex
+}
+
+// NewNameProviders creates NameProviders with default values which gets names from providers.
+func NewNameProviders(providers ...*NameProvider) *NameProviders {
+	for _, p := range providers {
+		p.stats.Healthy = true
+		metric.MNameProviderHealthy.WithLabelValues(p.Name).Set(1)
+	}
+	return &NameProviders{
+		Providers:               providers,
+		FailCooldown:            10 * time.Second,
+		TooManyRequestsCooldown: time.Minute,
+	}
+}
+
+// Names gets a batch of names from the first provider able to give them.  Providers are tried in order of priority
+// and by weight within the same priority, skipping any provider which is cooling down after a failure.  Providers of
+// a lower priority are only tried when every provider of a higher priority is cooling down or has just failed, if
+// any of them has only spent its budget we wait for it instead.  If no provider can be requested we sleep until one
+// can or ctx is done.  If every provider requested fails the last error is returned.
+func (p *NameProviders) Names(ctx context.Context) ([]Name, error) {
+	if len(p.Providers) == 0 {
+		return []Name{}, ErrNoNameProviders
+	}
+	for {
+		var tried bool
+		var lastErr error
+		// budgetNext is the earliest time a provider which has only spent its budget may be requested again, and
+		// budgetPriority is its priority
+		var budgetNext time.Time
+		var budgetPriority int
+		for _, prov := range p.order() {
+			if !budgetNext.IsZero() && prov.Priority != budgetPriority {
+				// a provider of a higher priority is healthy, so lower priorities aren't needed yet
+				break
+			}
+			if p.coolingDown(prov) {
+				continue
+			}
+			if prov.Budget != nil && !prov.Budget.Allow() {
+				if next := prov.Budget.Next(); budgetNext.IsZero() || next.Before(budgetNext) {
+					budgetNext = next
+					budgetPriority = prov.Priority
+				}
+				continue
+			}
+			tried = true
+			names, err := prov.Requester.Names(ctx)
+			if err != nil && ctx.Err() != nil {
+				// the provider was abandoned rather than failing
+				return []Name{}, ctx.Err()
+			}
+			p.record(prov, len(names), err)
+			if err == nil {
+				return names, nil
+			}
+			log.WithError(err).WithField("provider", prov.Name).Warn("name provider failed, falling over to next provider")
+			lastErr = err
+		}
+		if tried {
+			return []Name{}, errors.Wrap(lastErr, "all available name providers failed")
+		}
+
+		metric.MNamesBudgetWaitTotal.Inc()
+		next := budgetNext
+		if next.IsZero() {
+			next = p.nextReady()
+		}
+		log.WithField("next", next).Trace("no name providers available, waiting")
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return []Name{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Stats gets the stats of every provider, in the order providers were given.
+func (p *NameProviders) Stats() []NameProviderStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]NameProviderStats, 0, len(p.Providers))
+	for _, prov := range p.Providers {
+		s := prov.stats
+		s.Name = prov.Name
+		s.Priority = prov.Priority
+		s.Weight = prov.Weight
+		if c, ok := prov.Requester.(*NameClient); ok && c.Breaker != nil {
+			s.Breaker = c.Breaker.State().String()
+		}
+		s.Budget = budgetStats(prov.Budget)
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// order gets the providers in the order they should be tried, by priority and then randomly by weight.
+func (p *NameProviders) order() []*NameProvider {
+	byPriority := make(map[int][]*NameProvider)
+	var priorities []int
+	for _, prov := range p.Providers {
+		if _, ok := byPriority[prov.Priority]; !ok {
+			priorities = append(priorities, prov.Priority)
+		}
+		byPriority[prov.Priority] = append(byPriority[prov.Priority], prov)
+	}
+	sort.Ints(priorities)
+
+	order := make([]*NameProvider, 0, len(p.Providers))
+	for _, priority := range priorities {
+		order = append(order, weightedShuffle(byPriority[priority])...)
+	}
+	return order
+}
+
+// weightedShuffle orders providers randomly, where providers with a higher weight are more likely to come first.
+func weightedShuffle(providers []*NameProvider) []*NameProvider {
+	remaining := append([]*NameProvider(nil), providers...)
+	shuffled := make([]*NameProvider, 0, len(providers))
+	for len(remaining) > 0 {
+		var total int
+		for _, prov := range remaining {
+			total += weight(prov)
+		}
+		pick := rand.Intn(total)
+		for i, prov := range remaining {
+			pick -= weight(prov)
+			if pick < 0 {
+				shuffled = append(shuffled, prov)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+	return shuffled
+}
+
+func weight(prov *NameProvider) int {
+	if prov.Weight < 1 {
+		return 1
+	}
+	return prov.Weight
+}
+
+// coolingDown returns true if prov is being left alone after a failed request.
+func (p *NameProviders) coolingDown(prov *NameProvider) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Now().Before(prov.stats.RetryAt)
+}
+
+// nextReady is the earliest time any provider will be ready to be requested.
+func (p *NameProviders) nextReady() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var next time.Time
+	for i, prov := range p.Providers {
+		at := prov.stats.RetryAt
+		if prov.Budget != nil && prov.Budget.Next().After(at) {
+			at = prov.Budget.Next()
+		}
+		if i == 0 || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
+
+// record updates the stats of prov after a request which got n names or failed with err.
+func (p *NameProviders) record(prov *NameProvider, n int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &prov.stats
+	result := "success"
+	retry, breakerOpen := breaker.RetryAfter(err)
+	retryAfter, askedToWait := upstreamRetryAfter(err)
+	if !breakerOpen {
+		s.Requests++
+	}
+	switch {
+	case err == nil:
+		s.Healthy = true
+		s.Names += n
+		s.LastSuccess = time.Now()
+		s.RetryAt = time.Time{}
+	case errors.Cause(err) == ErrNamesApiTooManyRequests:
+		result = "too_many_requests"
+		s.Healthy = false
+		s.Failures++
+		s.TooManyRequests++
+		s.LastError = err.Error()
+		s.LastErrorAt = time.Now()
+		s.RetryAt = time.Now().Add(p.TooManyRequestsCooldown)
+		if askedToWait {
+			s.RetryAt = time.Now().Add(retryAfter)
+		}
+	case breakerOpen:
+		// the provider wasn't requested, so it is left alone until its breaker allows a trial request
+		result = "circuit_open"
+		s.Healthy = false
+		s.LastError = err.Error()
+		s.LastErrorAt = time.Now()
+		s.RetryAt = time.Now().Add(retry)
+	default:
+		result = "error"
+		s.Healthy = false
+		s.Failures++
+		s.LastError = err.Error()
+		s.LastErrorAt = time.Now()
+		s.RetryAt = time.Now().Add(p.FailCooldown)
+		if askedToWait {
+			s.RetryAt = time.Now().Add(retryAfter)
+		}
+	}
+	metric.ObserveNameProvider(prov.Name, result, s.Healthy)
+}
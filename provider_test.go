@@ -0,0 +1,233 @@
+package jokesontap
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/swtch1/jokesontap/budget"
+	"github.com/swtch1/jokesontap/retry"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubNameRequester returns names named after itself, or err when set.
+type stubNameRequester struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (r *stubNameRequester) Names(ctx context.Context) ([]Name, error) {
+	r.calls++
+	if r.err != nil {
+		return []Name{}, r.err
+	}
+	return []Name{{Name: r.name, Surname: r.name}}, nil
+}
+
+func TestNameProvidersFallOverToNextProvider(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"error", errors.New("connection refused")},
+		{"too_many_requests", ErrNamesApiTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			primary := &stubNameRequester{name: "primary", err: tt.err}
+			backup := &stubNameRequester{name: "backup"}
+			p := NewNameProviders(
+				&NameProvider{Name: "primary", Requester: primary},
+				&NameProvider{Name: "backup", Requester: backup, Priority: 1},
+			)
+
+			names, err := p.Names(context.Background())
+			assert.Nil(err)
+			assert.Equal([]Name{{"backup", "backup"}}, names)
+
+			// the failed provider cools down rather than being tried on every request
+			_, err = p.Names(context.Background())
+			assert.Nil(err)
+			assert.Equal(1, primary.calls)
+			assert.Equal(2, backup.calls)
+
+			stats := p.Stats()
+			assert.False(stats[0].Healthy)
+			assert.Equal(1, stats[0].Failures)
+			assert.True(stats[0].RetryAt.After(time.Now()))
+			assert.True(stats[1].Healthy)
+			assert.Equal(2, stats[1].Names)
+		})
+	}
+}
+
+func TestNameProvidersRetryAfterCooldown(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	primary := &stubNameRequester{name: "primary", err: errors.New("unavailable")}
+	p := NewNameProviders(&NameProvider{Name: "primary", Requester: primary})
+	p.FailCooldown = 50 * time.Millisecond
+
+	_, err := p.Names(context.Background())
+	assert.NotNil(err)
+	primary.err = nil
+
+	start := time.Now()
+	names, err := p.Names(context.Background())
+	assert.Nil(err)
+	assert.Equal([]Name{{"primary", "primary"}}, names)
+	assert.True(time.Since(start) >= 40*time.Millisecond, "expected to wait for the cooldown")
+	assert.True(p.Stats()[0].Healthy)
+}
+
+func TestNameProvidersRespectProviderBudgets(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	primary := &stubNameRequester{name: "primary"}
+	backup := &stubNameRequester{name: "backup"}
+	p := NewNameProviders(
+		&NameProvider{Name: "primary", Requester: primary, Budget: budget.NewSlidingWindow(1, time.Hour)},
+		&NameProvider{Name: "backup", Requester: backup, Priority: 1},
+	)
+
+	names, err := p.Names(context.Background())
+	assert.Nil(err)
+	assert.Equal("primary", names[0].Name)
+
+	// a spent budget is waited for rather than falling over to the backup
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = p.Names(ctx)
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Equal(1, primary.calls)
+	assert.Equal(0, backup.calls)
+}
+
+func TestNameProvidersPreferBudgetedPrimaryOverGenerator(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	gen, err := NewNameGenerator("", "", 1)
+	assert.Nil(err)
+	primary := &stubNameRequester{name: "primary"}
+	p := NewNameProviders(
+		&NameProvider{Name: "primary", Requester: primary, Budget: budget.NewSlidingWindow(2, 50*time.Millisecond)},
+		&NameProvider{Name: "generator", Requester: gen, Priority: 10},
+	)
+
+	for i := 0; i < 6; i++ {
+		names, err := p.Names(context.Background())
+		assert.Nil(err)
+		assert.Equal("primary", names[0].Name, "the generator is only a last resort")
+	}
+	assert.Equal(6, primary.calls)
+	assert.Equal(0, p.Stats()[1].Requests)
+
+	// once the primary fails the generator takes over
+	primary.err = errors.New("unavailable")
+	time.Sleep(60 * time.Millisecond)
+	names, err := p.Names(context.Background())
+	assert.Nil(err)
+	assert.NotEqual("primary", names[0].Name)
+	assert.Equal(1, p.Stats()[1].Requests)
+}
+
+func TestNameProvidersReturnLastErrorWhenAllFail(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	p := NewNameProviders(
+		&NameProvider{Name: "a", Requester: &stubNameRequester{err: errors.New("a failed")}},
+		&NameProvider{Name: "b", Requester: &stubNameRequester{err: ErrNamesApiTooManyRequests}, Priority: 1},
+	)
+	_, err := p.Names(context.Background())
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), ErrNamesApiTooManyRequests.Error())
+	}
+
+	_, err = NewNameProviders().Names(context.Background())
+	assert.Equal(ErrNoNameProviders, err)
+}
+
+func TestNameProvidersPreferHeavierProviders(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	p := NewNameProviders(
+		&NameProvider{Name: "light", Requester: &stubNameRequester{}, Weight: 1},
+		&NameProvider{Name: "heavy", Requester: &stubNameRequester{}, Weight: 99},
+		&NameProvider{Name: "fallback", Requester: &stubNameRequester{}, Priority: 1, Weight: 1000},
+	)
+
+	firsts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		order := p.order()
+		assert.Len(order, 3)
+		assert.Equal("fallback", order[2].Name, "lower priorities must always be tried first")
+		firsts[order[0].Name]++
+	}
+	assert.True(firsts["heavy"] > 900, "heavy provider was first %d of 1000 times", firsts["heavy"])
+}
+
+func TestNameProvidersHonorRetryAfter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+
+	nc := NewNameClient(*u)
+	_, err = nc.Names(context.Background())
+	assert.Contains(err.Error(), ErrNamesApiTooManyRequests.Error())
+	after, ok := upstreamRetryAfter(err)
+	assert.True(ok)
+	assert.Equal(2*time.Minute, after)
+
+	p := NewNameProviders(&NameProvider{Name: "rate_limited", Requester: nc})
+	p.TooManyRequestsCooldown = time.Second
+	_, err = p.Names(context.Background())
+	assert.NotNil(err)
+	// the provider is left alone for as long as it asked rather than the cool down
+	retryAt := p.Stats()[0].RetryAt
+	assert.True(retryAt.After(time.Now().Add(time.Minute+50*time.Second)), "retry at %s", retryAt)
+}
+
+func TestNameProvidersSendOneRequestPerBudgetedCall(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+
+	nc := NewNameClient(*u)
+	nc.Retry = retry.Policy{Retries: 2, Budget: 5 * time.Second}
+	p := NewNameProviders(&NameProvider{Name: "default", Requester: nc, Budget: budget.NewSlidingWindow(6, time.Minute)})
+	_, err = p.Names(context.Background())
+	assert.NotNil(err)
+	// a retry would be a request the budget doesn't know about
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(1, p.Stats()[0].Requests)
+}
@@ -0,0 +1,139 @@
+package jokesontap
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentTypeText = "text/plain"
+	contentTypeJson = "application/json"
+)
+
+// JokeResponse is the JSON representation of a joke served by the server.
+type JokeResponse struct {
+	// Joke is the joke text with the custom name substituted in.
+	Joke string `json:"joke"`
+	// ID is the ID of the joke in the jokes API.
+	ID         int      `json:"id"`
+	FirstName  string   `json:"firstName"`
+	LastName   string   `json:"lastName"`
+	Categories []string `json:"categories"`
+}
+
+func newJokeResponse(joke JokeValue, name Name) JokeResponse {
+	categories := joke.Categories
+	if categories == nil {
+		categories = []string{}
+	}
+	return JokeResponse{
+		Joke:       joke.Joke,
+		ID:         joke.ID,
+		FirstName:  name.Name,
+		LastName:   name.Surname,
+		Categories: categories,
+	}
+}
+
+// writeJoke writes a successful joke response in the given content type.
+func writeJoke(w http.ResponseWriter, req *http.Request, contentType string, resp JokeResponse) {
+	w.Header().Add("Vary", "Accept")
+	switch contentType {
+	case contentTypeJson:
+		w.Header().Set("Content-Type", contentTypeJson)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.WithContext(req.Context()).WithError(err).Error("unable to write joke response")
+		}
+	default:
+		w.Header().Set("Content-Type", contentTypeText+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, resp.Joke, "\n")
+	}
+}
+
+// JokesResponse is the JSON representation of a batch of jokes served by the server.
+type JokesResponse struct {
+	Jokes []JokeResponse `json:"jokes"`
+}
+
+// writeJokes writes a successful batch of jokes in the given content type, one joke per line for plain text.
+func writeJokes(w http.ResponseWriter, req *http.Request, contentType string, jokes []JokeResponse) {
+	w.Header().Add("Vary", "Accept")
+	switch contentType {
+	case contentTypeJson:
+		w.Header().Set("Content-Type", contentTypeJson)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(JokesResponse{Jokes: jokes}); err != nil {
+			log.WithContext(req.Context()).WithError(err).Error("unable to write jokes response")
+		}
+	default:
+		w.Header().Set("Content-Type", contentTypeText+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		for _, joke := range jokes {
+			fmt.Fprint(w, joke.Joke, "\n")
+		}
+	}
+}
+
+// CategoriesResponse is the JSON representation of the joke categories served by the server.
+type CategoriesResponse struct {
+	Categories []string `json:"categories"`
+}
+
+// writeCategories writes a successful categories response in the given content type, one category per line for
+// plain text.
+func writeCategories(w http.ResponseWriter, req *http.Request, contentType string, categories []string) {
+	if categories == nil {
+		categories = []string{}
+	}
+	w.Header().Add("Vary", "Accept")
+	switch contentType {
+	case contentTypeJson:
+		w.Header().Set("Content-Type", contentTypeJson)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(CategoriesResponse{Categories: categories}); err != nil {
+			log.WithContext(req.Context()).WithError(err).Error("unable to write categories response")
+		}
+	default:
+		w.Header().Set("Content-Type", contentTypeText+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		for _, c := range categories {
+			fmt.Fprint(w, c, "\n")
+		}
+	}
+}
+
+// negotiate picks the content type to respond with from the Accept request header.  Plain text is preferred
+// unless the client ranks JSON higher.
+func negotiate(accept string) string {
+	textQ, jsonQ := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case contentTypeJson, "application/*":
+			jsonQ = math.Max(jsonQ, q)
+		case contentTypeText, "text/*", "*/*":
+			textQ = math.Max(textQ, q)
+		}
+	}
+	if jsonQ > textQ {
+		return contentTypeJson
+	}
+	return contentTypeText
+}
@@ -0,0 +1,104 @@
+package jokesontap
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNegotiatingContentType(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		accept         string
+		expContentType string
+	}{
+		{"", contentTypeText},
+		{"*/*", contentTypeText},
+		{"text/plain", contentTypeText},
+		{"application/json", contentTypeJson},
+		{"application/json, text/plain", contentTypeText},
+		{"text/plain;q=0.5, application/json", contentTypeJson},
+		{"application/json;q=0.9, */*;q=0.8", contentTypeJson},
+		{"text/html, application/xhtml+xml", contentTypeText},
+		{"not a media type", contentTypeText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			assert.Equal(tt.expContentType, negotiate(tt.accept))
+		})
+	}
+}
+
+func TestServingJokesAsJson(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, `{"type": "success", "value": { "id": 42, "joke": "Chuck Norris speaks JSON.", "categories": ["nerdy"]}}`)
+		assert.Nil(err)
+	}))
+	defer ts.Close()
+	jokeUrl, err := url.Parse(ts.URL)
+	assert.Nil(err)
+
+	tests := []struct {
+		name    string
+		accept  string
+		handler func(*Server) http.HandlerFunc
+	}{
+		{"accept_header", "application/json", func(s *Server) http.HandlerFunc { return s.GetCustomJoke }},
+		{"api_route", "", func(s *Server) http.HandlerFunc { return s.GetCustomJokeJson }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nameChan := make(chan Name, 1)
+			nameChan <- Name{Name: "Grace", Surname: "Hopper"}
+			srv := &Server{
+				JokeClient: NewJokeClient(*jokeUrl),
+				Names:      nameChan,
+			}
+
+			req := httptest.NewRequest("GET", "http://doesnt.matter", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(srv)(w, req)
+
+			assert.Equal(http.StatusOK, w.Result().StatusCode)
+			assert.Equal(contentTypeJson, w.Result().Header.Get("Content-Type"))
+			body, err := ioutil.ReadAll(w.Result().Body)
+			assert.Nil(err)
+			var resp JokeResponse
+			assert.Nil(json.Unmarshal(body, &resp))
+			assert.Equal(JokeResponse{
+				Joke:       "Grace Hopper speaks JSON.",
+				ID:         42,
+				FirstName:  "Grace",
+				LastName:   "Hopper",
+				Categories: []string{"nerdy"},
+			}, resp)
+		})
+	}
+}
+
+func TestServingJokesAsTextByDefault(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	writeJoke(w, httptest.NewRequest("GET", "/", nil), negotiate(""), JokeResponse{Joke: "plain old joke"})
+	body, err := ioutil.ReadAll(w.Result().Body)
+	assert.Nil(err)
+	assert.Equal("plain old joke\n", string(body))
+	assert.Equal("text/plain; charset=utf-8", w.Result().Header.Get("Content-Type"))
+}
@@ -0,0 +1,178 @@
+// Package retry retries idempotent requests to upstream APIs which fail for reasons likely to pass, backing off
+// exponentially with jitter between tries and honoring any Retry-After the upstream API asks for.
+package retry
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy describes how failed requests are retried.  The zero Policy never retries.
+type Policy struct {
+	// Retries is the most times a single request is retried after its first try.
+	Retries int
+	// BaseDelay is the longest wait before the first retry, doubling for each retry after.  The actual wait is
+	// picked at random up to this limit so that clients which failed together don't retry together.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait before any retry.
+	MaxDelay time.Duration
+	// Budget is the most time a single request may spend waiting between tries.  A retry which would wait longer
+	// than what is left of the budget, including one the upstream API asked for with Retry-After, is not made.
+	// There is no limit when 0.
+	Budget time.Duration
+	// SkipTooManyRequests is true when a 429 is never retried, for APIs whose rate limit is kept by a budget which
+	// would not count the retries.
+	SkipTooManyRequests bool
+}
+
+// Backoff gets how long to wait before retry n, counting from 0, with full jitter.
+func (p Policy) Backoff(n int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 0; i < n && (p.MaxDelay <= 0 || ceiling < p.MaxDelay); i++ {
+		ceiling *= 2
+	}
+	if p.MaxDelay > 0 && ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(random.Int63n(int64(ceiling) + 1))
+}
+
+// random picks backoff jitter.  The global source is not used so that tests which seed it stay repeatable.
+var random = &lockedRand{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (l *lockedRand) Int63n(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int63n(n)
+}
+
+// Do sends req with client, retrying it by the policy.  Only idempotent requests are retried, and only after a
+// connection error or a response which says the upstream API is briefly unavailable: 429, 502, 503 or 504.  A 429 is
+// only retried when the upstream API says when with Retry-After, as retrying sooner only prolongs the rate limiting,
+// and never with SkipTooManyRequests.
+// Requests which timed out are not retried, as the time they were allowed is already spent.  When no retry is made
+// the last response or error is returned as is.
+func (p Policy) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if !idempotent(req) {
+		return client.Do(req)
+	}
+
+	ctx := req.Context()
+	var waited time.Duration
+	for n := 0; ; n++ {
+		try := req
+		if n > 0 && req.GetBody != nil {
+			// the body of the last try has been consumed
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try = req.Clone(ctx)
+			try.Body = body
+		}
+		resp, err := client.Do(try)
+
+		wait, retryable := p.retryable(ctx, n, resp, err)
+		if !retryable || n >= p.Retries || (p.Budget > 0 && waited+wait > p.Budget) {
+			return resp, err
+		}
+		fields := log.Fields{"url": req.URL.String(), "retry": n + 1, "wait": wait}
+		if err != nil {
+			log.WithContext(ctx).WithError(err).WithFields(fields).Debug("retrying failed upstream request")
+		} else {
+			log.WithContext(ctx).WithFields(fields).WithField("statusCode", resp.StatusCode).Debug("retrying failed upstream request")
+			// the connection is only reused once the body has been read
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		waited += wait
+	}
+}
+
+// retryable gets whether try n, which got resp or failed with err, should be retried and how long to wait first.
+func (p Policy) retryable(ctx context.Context, n int, resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return 0, false
+		}
+		// a request abandoned by the caller failed for a reason which won't pass
+		return p.Backoff(n), ctx.Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		if p.SkipTooManyRequests {
+			return 0, false
+		}
+		return After(resp)
+	case http.StatusServiceUnavailable:
+		if wait, ok := After(resp); ok {
+			return wait, true
+		}
+		return p.Backoff(n), true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return p.Backoff(n), true
+	}
+	return 0, false
+}
+
+// idempotent returns true if req can be sent more than once with the same effect as sending it once.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
+
+// After gets how long resp asks the client to wait before trying again with its Retry-After header, given either
+// as a number of seconds or as a date.
+// ref: https://tools.ietf.org/html/rfc7231#section-7.1.3
+func After(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	wait := time.Until(at)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
@@ -0,0 +1,193 @@
+package retry
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyApi responds with each of statuses in turn, setting Retry-After when retryAfter is not empty, and with 200
+// once they run out.  The number of requests made is counted in requests.
+func flakyApi(requests *int32, retryAfter string, statuses ...int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(requests, 1))
+		if n > len(statuses) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(statuses[n-1])
+	}))
+}
+
+func TestBackoffIsBoundedAndJittered(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	p := Policy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	tests := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{0, 10 * time.Millisecond},
+		{1, 20 * time.Millisecond},
+		{2, 40 * time.Millisecond},
+		{3, 50 * time.Millisecond},
+		{30, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 50; i++ {
+			wait := p.Backoff(tt.retry)
+			assert.True(wait >= 0 && wait <= tt.max, "retry %d waited %s", tt.retry, wait)
+			seen[wait] = true
+		}
+		assert.True(len(seen) > 1, "retry %d should be jittered", tt.retry)
+	}
+	assert.Equal(time.Duration(0), Policy{}.Backoff(3))
+}
+
+func TestDoRetries(t *testing.T) {
+	t.Parallel()
+
+	policy := Policy{Retries: 2, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	tests := []struct {
+		name        string
+		method      string
+		retryAfter  string
+		statuses    []int
+		expStatus   int
+		expRequests int32
+	}{
+		{"success", "GET", "", nil, http.StatusOK, 1},
+		{"bad_gateway", "GET", "", []int{http.StatusBadGateway}, http.StatusOK, 2},
+		{"gateway_timeout", "GET", "", []int{http.StatusGatewayTimeout, http.StatusGatewayTimeout}, http.StatusOK, 3},
+		{"unavailable_with_retry_after", "GET", "0", []int{http.StatusServiceUnavailable}, http.StatusOK, 2},
+		{"too_many_requests_with_retry_after", "GET", "0", []int{http.StatusTooManyRequests}, http.StatusOK, 2},
+		{"too_many_requests_without_retry_after", "GET", "", []int{http.StatusTooManyRequests}, http.StatusTooManyRequests, 1},
+		{"internal_error", "GET", "", []int{http.StatusInternalServerError}, http.StatusInternalServerError, 1},
+		{"not_found", "GET", "", []int{http.StatusNotFound}, http.StatusNotFound, 1},
+		{"retries_spent", "GET", "", []int{502, 502, 502}, http.StatusBadGateway, 3},
+		{"not_idempotent", "POST", "", []int{http.StatusBadGateway}, http.StatusBadGateway, 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert := assert.New(t)
+			var requests int32
+			ts := flakyApi(&requests, tt.retryAfter, tt.statuses...)
+			defer ts.Close()
+
+			var body io.Reader
+			if tt.method == "POST" {
+				body = strings.NewReader("{}")
+			}
+			req, err := http.NewRequest(tt.method, ts.URL, body)
+			assert.Nil(err)
+			resp, err := policy.Do(http.DefaultClient, req)
+			assert.Nil(err)
+			defer resp.Body.Close()
+			assert.Equal(tt.expStatus, resp.StatusCode)
+			assert.Equal(tt.expRequests, atomic.LoadInt32(&requests))
+		})
+	}
+}
+
+func TestDoSkipsTooManyRequests(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var requests int32
+	ts := flakyApi(&requests, "0", http.StatusTooManyRequests, http.StatusBadGateway)
+	defer ts.Close()
+
+	policy := Policy{Retries: 2, SkipTooManyRequests: true}
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	assert.Nil(err)
+	resp, err := policy.Do(http.DefaultClient, req)
+	assert.Nil(err)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestDoKeepsWithinBudget(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var requests int32
+	ts := flakyApi(&requests, "60", http.StatusServiceUnavailable)
+	defer ts.Close()
+
+	policy := Policy{Retries: 3, BaseDelay: time.Millisecond, Budget: time.Second}
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	assert.Nil(err)
+	start := time.Now()
+	resp, err := policy.Do(http.DefaultClient, req)
+	assert.Nil(err)
+	defer resp.Body.Close()
+
+	// waiting the minute asked for would spend more than the budget, so the response is returned at once
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	assert.True(time.Since(start) < time.Second)
+}
+
+func TestDoDoesNotRetryTimeouts(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	policy := Policy{Retries: 3, BaseDelay: time.Millisecond}
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	assert.Nil(err)
+	_, err = policy.Do(&http.Client{Timeout: 10 * time.Millisecond}, req)
+	assert.NotNil(err)
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestAfter(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		header string
+		expOk  bool
+		expMin time.Duration
+		expMax time.Duration
+	}{
+		{"", false, 0, 0},
+		{"120", true, 2 * time.Minute, 2 * time.Minute},
+		{" 5 ", true, 5 * time.Second, 5 * time.Second},
+		{"-1", false, 0, 0},
+		{"soon", false, 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), true, 58 * time.Second, time.Minute},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), true, 0, 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Retry-After", tt.header)
+		}
+		wait, ok := After(resp)
+		assert.Equal(tt.expOk, ok, tt.header)
+		assert.True(wait >= tt.expMin && wait <= tt.expMax, "%s waited %s", tt.header, wait)
+	}
+	_, ok := After(nil)
+	assert.False(ok)
+}
@@ -1,16 +1,26 @@
 package jokesontap
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/swtch1/jokesontap/metric"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// defaultMaxJokes is the most jokes served by a single batch request when the server doesn't set a maximum.
+const defaultMaxJokes = 50
+
 var (
+	ErrInvalidJokeCount       = errors.New("invalid joke count")
 	ErrNamesChanUninitialized = errors.New("the server's names channel is uninitialized, please submit an issue")
 	ErrNoNamesAvailable       = errors.New("the server has no names to provide")
+	ErrNoPinnedName           = errors.New("no previously used name is available for this client")
 )
 
 type Server struct {
@@ -18,12 +28,44 @@ type Server struct {
 	Port int32
 	// JokeClient requests new jokes using a customized name, if given.
 	JokeClient *JokeClient
+	// JokeStore serves jokes from memory.  When set and populated it is used instead of the JokeClient.
+	JokeStore *JokeStore
 	// Names is a buffered channel where names will be retrieved from.  The server expects for this
 	// to be populated ahead of time by another thread.  We are basically using this as a queue, but the
 	// implementation is more simple and more easily supports handling timeouts.
 	Names chan Name
+	// NameTaken, when set, is called each time a fresh name is taken from the Names channel so that whatever
+	// populates the channel knows there is room for more.
+	NameTaken func()
+	// Pending, when set, is kept up to date with the names waiting in the Names channel.
+	Pending *PendingNames
+	// NameCache holds names which have already been served.  When set, names are reused from the cache when
+	// the Names channel has run dry, rather than making the client wait for a new name.
+	NameCache Cacher
+	// Pins holds the name last served to each client, keyed by client key.  When set, clients which send
+	// a Cache-Control max-age or only-if-cached directive get the same name they were served last time.
+	Pins Cacher
+	// TrustUserNameHeader, when true, serves jokes about the user named in the X-User-Name header.  This should only
+	// be set when the server is behind a proxy which sets the header itself.
+	TrustUserNameHeader bool
+	// Categories are the joke categories served when a request doesn't ask for any.  Jokes in any category are
+	// served when empty.
+	Categories []string
+	// MaxJokes is the most jokes served by a single request to /jokes.  Defaults to 50 when not set.
+	MaxJokes int
+	// AccessLog, when set, writes a line for every request served.
+	AccessLog *AccessLogger
+	// Readiness decides whether the server is ready to serve jokes, as reported on /readyz.
+	Readiness *Readiness
+
+	// mu guards httpSrv and shutdown.
+	mu       sync.Mutex
+	httpSrv  *http.Server
+	shutdown bool
 }
 
+// ListenAndServe listens on the server's port and serves jokes until the server is shut down.  After Shutdown is
+// called ListenAndServe returns http.ErrServerClosed.
 func (s *Server) ListenAndServe() error {
 	if s.Names == nil {
 		return ErrNamesChanUninitialized
@@ -31,32 +73,360 @@ func (s *Server) ListenAndServe() error {
 
 	mux := http.NewServeMux()
 	// TODO: ensure only the GET verb can be called on this endpoint
-	mux.HandleFunc("/", s.GetCustomJoke)
+	mux.HandleFunc("/", instrument("/", s.GetCustomJoke))
+	mux.HandleFunc("/api/v1/joke", instrument("/api/v1/joke", s.GetCustomJokeJson))
+	mux.HandleFunc("/jokes", instrument("/jokes", s.GetJokes))
+	mux.HandleFunc("/jokes/", instrument("/jokes/{id}", s.GetJokeByID))
+	mux.HandleFunc("/categories", instrument("/categories", s.GetCategories))
+	mux.HandleFunc("/healthz", instrument("/healthz", s.Healthz))
+	mux.HandleFunc("/readyz", instrument("/readyz", s.Readyz))
 	httpSrv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", s.Port),
-		Handler:      mux,
+		Handler:      withRequestID(s.AccessLog.Handler(mux)),
 		ReadTimeout:  3 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  30 * time.Second,
 	}
+
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return http.ErrServerClosed
+	}
+	s.httpSrv = httpSrv
+	s.mu.Unlock()
 	return httpSrv.ListenAndServe()
 }
 
+// Shutdown gracefully stops the server, waiting for in-flight requests to complete until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	httpSrv := s.httpSrv
+	s.mu.Unlock()
+
+	if httpSrv == nil {
+		return nil
+	}
+	return httpSrv.Shutdown(ctx)
+}
+
+// GetCustomJoke serves a joke with a random name as plain text, or as JSON if the client prefers it.
 func (s *Server) GetCustomJoke(w http.ResponseWriter, req *http.Request) {
-	log.Trace("custom joke request")
+	s.serveCustomJoke(w, req, negotiate(req.Header.Get("Accept")))
+}
+
+// GetCustomJokeJson serves a joke with a random name as JSON.
+func (s *Server) GetCustomJokeJson(w http.ResponseWriter, req *http.Request) {
+	s.serveCustomJoke(w, req, contentTypeJson)
+}
+
+// serveCustomJoke serves a joke in the given content type, about the person the caller asked for or otherwise with a
+// random name.
+func (s *Server) serveCustomJoke(w http.ResponseWriter, req *http.Request, contentType string) {
+	log.WithContext(req.Context()).WithField("contentType", contentType).Trace("custom joke request")
+	name, given, err := callerName(req, s.TrustUserNameHeader)
+	if err != nil {
+		writeError(w, req, contentType, err)
+		return
+	}
+	filter, err := categoryFilter(req, s.Categories)
+	if err != nil {
+		writeError(w, req, contentType, err)
+		return
+	}
+
+	// caller supplied names never touch the names channel or the names pinned to clients
+	cc := parseCacheControl(req.Header.Get("Cache-Control"))
+	var age time.Duration
+	var fresh bool
+	if !given {
+		if name, age, fresh, err = s.randomName(w, req, cc); err != nil {
+			writeError(w, req, contentType, err)
+			return
+		}
+	}
+	joke, err := s.jokeWithCustomName(req.Context(), name.Name, name.Surname, filter)
+	if err != nil {
+		if fresh {
+			// the name was never served, so it's given back for the next request
+			s.giveBackNames([]Name{name})
+		}
+		writeError(w, req, contentType, err)
+		return
+	}
+	if !given {
+		setCacheHeaders(w, cc, age)
+	}
+	writeJoke(w, req, contentType, newJokeResponse(joke, name))
+}
+
+// GetJokes serves the number of jokes given by the count query parameter, each with a different random name unless
+// the caller asked for a name.  Jokes are served as plain text with one joke per line, or as JSON if the client
+// prefers it.
+func (s *Server) GetJokes(w http.ResponseWriter, req *http.Request) {
+	contentType := negotiate(req.Header.Get("Accept"))
+	count, err := s.jokeCount(req)
+	if err != nil {
+		writeError(w, req, contentType, err)
+		return
+	}
+	name, given, err := callerName(req, s.TrustUserNameHeader)
+	if err != nil {
+		writeError(w, req, contentType, err)
+		return
+	}
+	filter, err := categoryFilter(req, s.Categories)
+	if err != nil {
+		writeError(w, req, contentType, err)
+		return
+	}
+
+	names := make([]Name, count)
+	var fresh []Name
+	if given {
+		for i := range names {
+			names[i] = name
+		}
+	} else if names, fresh, err = s.distinctNames(req.Context(), count); err != nil {
+		writeError(w, req, contentType, err)
+		return
+	}
+
+	jokes, err := s.jokesWithCustomNames(req.Context(), names, filter)
+	if err != nil {
+		// the names were never served, so they're given back for the next request
+		s.giveBackNames(fresh)
+		writeError(w, req, contentType, err)
+		return
+	}
+	resp := make([]JokeResponse, 0, len(jokes))
+	for i, joke := range jokes {
+		resp = append(resp, newJokeResponse(joke, names[i]))
+	}
+	writeJokes(w, req, contentType, resp)
+}
+
+// GetJokeByID serves the joke with the ID given in the path, /jokes/{id}, about the person the caller asked for or
+// otherwise with a random name.  The joke is served as plain text or as JSON if the client prefers it.
+func (s *Server) GetJokeByID(w http.ResponseWriter, req *http.Request) {
+	contentType := negotiate(req.Header.Get("Accept"))
+	id, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/jokes/"))
+	if err != nil || id < 1 {
+		writeError(w, req, contentType, ErrJokeNotFound)
+		return
+	}
+	name, given, err := callerName(req, s.TrustUserNameHeader)
+	if err != nil {
+		writeError(w, req, contentType, err)
+		return
+	}
+
+	joke, err := s.jokeByID(req.Context(), id)
+	if err != nil {
+		writeError(w, req, contentType, err)
+		return
+	}
+	if !hasPlaceholder(joke.Joke) {
+		// the exact joke was asked for, so it is served as is even without a name in it, and no name is spent on it
+		writeJoke(w, req, contentType, newJokeResponse(joke, Name{}))
+		return
+	}
+	if !given {
+		if name, err = s.nextName(req.Context()); err != nil {
+			writeError(w, req, contentType, err)
+			return
+		}
+	}
+
+	if joke.Joke, err = substituteName(joke.Joke, name.Name, name.Surname); err != nil {
+		writeError(w, req, contentType, err)
+		return
+	}
+	writeJoke(w, req, contentType, newJokeResponse(joke, name))
+}
+
+// jokeCount gets the number of jokes asked for by the count query parameter, 1 if not given.  An ErrInvalidJokeCount
+// error is returned if count is not a number from 1 to the server's maximum.
+func (s *Server) jokeCount(req *http.Request) (int, error) {
+	max := s.MaxJokes
+	if max <= 0 {
+		max = defaultMaxJokes
+	}
+	raw := req.URL.Query().Get("count")
+	if raw == "" {
+		return 1, nil
+	}
+	count, err := strconv.Atoi(raw)
+	if err != nil || count < 1 || count > max {
+		return 0, errors.Wrapf(ErrInvalidJokeCount, "count must be a number from 1 to %d", max)
+	}
+	return count, nil
+}
+
+// distinctNames gets n different names, along with every fresh name taken from the Names channel to get them.
+// Names reused from the NameCache may repeat, so we make a few extra attempts before giving up with
+// ErrNoNamesAvailable.  On error the fresh names are given back.
+func (s *Server) distinctNames(ctx context.Context, n int) ([]Name, []Name, error) {
+	names := make([]Name, 0, n)
+	var fresh []Name
+	seen := make(map[Name]bool, n)
+	for attempts := 0; len(names) < n && attempts < 3*n; attempts++ {
+		name, isFresh, err := s.takeName(ctx)
+		if err != nil {
+			s.giveBackNames(fresh)
+			return nil, nil, err
+		}
+		if isFresh {
+			fresh = append(fresh, name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) < n {
+		s.giveBackNames(fresh)
+		return nil, nil, ErrNoNamesAvailable
+	}
+	return names, fresh, nil
+}
+
+// randomName gets a random name for the client, reusing the name pinned to the client when the request's
+// Cache-Control allows it, and returns how long ago the name was first served and whether it is a fresh name from
+// the Names channel.
+func (s *Server) randomName(w http.ResponseWriter, req *http.Request, cc cacheControl) (Name, time.Duration, bool, error) {
+	var key string
+	if s.Pins != nil {
+		key = s.clientKey(w, req)
+	}
+
+	pin, pinned := s.pinnedName(key)
+	switch {
+	case pinned && cc.wantsCached() && cc.allows(time.Since(pin.At)):
+		log.WithContext(req.Context()).Trace("reusing name pinned to client")
+		return pin.Name, time.Since(pin.At), false, nil
+	case cc.onlyIfCached:
+		return Name{}, 0, false, ErrNoPinnedName
+	}
+
+	name, fresh, err := s.takeName(req.Context())
+	if err != nil {
+		return Name{}, 0, false, err
+	}
+	s.pinName(key, name)
+	return name, 0, fresh, nil
+}
+
+// nextName gets a fresh name from the Names channel.  If no fresh names are ready, a previously served name
+// is reused from the NameCache.  Without either we wait a short time for a fresh name to become available, or
+// until ctx is done.
+func (s *Server) nextName(ctx context.Context) (Name, error) {
+	name, _, err := s.takeName(ctx)
+	return name, err
+}
+
+// takeName gets a name like nextName, also returning whether it is a fresh name from the Names channel.
+func (s *Server) takeName(ctx context.Context) (Name, bool, error) {
 	select {
 	case name := <-s.Names:
-		joke, err := s.JokeClient.JokeWithCustomName(name.Name, name.Surname)
-		if err != nil {
-			log.WithError(err).Error("failed to get joke with custom name")
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, err, "\n")
+		s.usedName(name)
+		return name, true, nil
+	default:
+	}
+
+	if s.NameCache != nil {
+		if cached, ok := s.NameCache.Random(); ok {
+			log.WithContext(ctx).Debug("no fresh names available, reusing a cached name")
+			return cached.(Name), false, nil
 		}
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, joke, "\n")
+	}
+
+	select {
+	case name := <-s.Names:
+		s.usedName(name)
+		return name, true, nil
 	case <-time.After(time.Second * 5):
-		log.WithError(ErrNoNamesAvailable).Error("timeout getting name")
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, ErrNoNamesAvailable, "\n")
+		return Name{}, false, ErrNoNamesAvailable
+	case <-ctx.Done():
+		return Name{}, false, ctx.Err()
+	}
+}
+
+// giveBackNames puts fresh names which were never served back in the Names channel.  Any which don't fit are
+// dropped, they were already added to the NameCache when taken so they can still be reused.
+func (s *Server) giveBackNames(names []Name) {
+	if len(names) == 0 {
+		return
+	}
+	defer func() { metric.ObserveNamesChan(len(s.Names), cap(s.Names)) }()
+	for i, name := range names {
+		select {
+		case s.Names <- name:
+			s.Pending.Add(name)
+		default:
+			log.WithField("dropped", len(names)-i).Debug("names channel is full, dropping names given back")
+			return
+		}
+	}
+}
+
+// usedName records that a fresh name was taken from the Names channel, storing it so that it can be reused later.
+func (s *Server) usedName(name Name) {
+	s.Pending.Remove(name)
+	metric.ObserveNamesChan(len(s.Names), cap(s.Names))
+	if s.NameTaken != nil {
+		s.NameTaken()
+	}
+	if s.NameCache != nil {
+		s.NameCache.Add(name, name)
+	}
+}
+
+// jokeWithCustomName gets a joke passing filter from the in memory store when it has jokes to serve, falling back to
+// the JokeClient otherwise.  Any request to the jokes API is abandoned when ctx is done.
+func (s *Server) jokeWithCustomName(ctx context.Context, fName, lName string, filter CategoryFilter) (JokeValue, error) {
+	if s.JokeStore != nil && s.JokeStore.Size() > 0 {
+		return s.JokeStore.JokeWithCustomName(fName, lName, filter)
+	}
+	return s.JokeClient.JokeWithCustomName(ctx, fName, lName, filter)
+}
+
+// jokesWithCustomNames gets a joke passing filter for each of names from the in memory store when it has jokes to
+// serve, falling back to the JokeClient otherwise.  Any request to the jokes API is abandoned when ctx is done.
+func (s *Server) jokesWithCustomNames(ctx context.Context, names []Name, filter CategoryFilter) ([]JokeValue, error) {
+	if s.JokeStore != nil && s.JokeStore.Size() > 0 {
+		return s.JokeStore.JokesWithCustomNames(names, filter)
+	}
+	return s.JokeClient.JokesWithCustomNames(ctx, names, filter)
+}
+
+// jokeByID gets the joke with the given ID from the in memory store when it holds the joke, falling back to the
+// JokeClient otherwise.  Any request to the jokes API is abandoned when ctx is done.
+func (s *Server) jokeByID(ctx context.Context, id int) (JokeValue, error) {
+	if s.JokeStore != nil {
+		if joke, ok := s.JokeStore.JokeByID(id); ok {
+			return joke, nil
+		}
+	}
+	return s.JokeClient.JokeByID(ctx, id)
+}
+
+// GetCategories serves the joke categories known to the in memory store, or to the jokes API while the store is
+// empty, as plain text or as JSON if the client prefers it.
+func (s *Server) GetCategories(w http.ResponseWriter, req *http.Request) {
+	contentType := negotiate(req.Header.Get("Accept"))
+	var categories []string
+	if s.JokeStore != nil && s.JokeStore.Size() > 0 {
+		categories = s.JokeStore.Categories()
+	} else {
+		var err error
+		categories, err = s.JokeClient.Categories(req.Context())
+		if err != nil {
+			writeError(w, req, contentType, err)
+			return
+		}
 	}
+	writeCategories(w, req, contentType, categories)
 }
@@ -1,13 +1,16 @@
 package jokesontap
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/swtch1/jokesontap/metric"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestStartingServerWithNilNamesChanErrors(t *testing.T) {
@@ -24,10 +27,11 @@ func TestServer(t *testing.T) {
 	assert := assert.New(t)
 
 	tests := []struct {
-		name string
-		joke string
+		name    string
+		joke    string
+		expJoke string
 	}{
-		{"basic_response", "Chuck Norris wants to be Bill Murray"},
+		{"basic_response", "Chuck Norris wants to be a lumberjack", "Bill Murray wants to be a lumberjack"},
 	}
 
 	for _, tt := range tests {
@@ -62,7 +66,162 @@ func TestServer(t *testing.T) {
 			assert.Nil(err)
 			// we expect a 200 response and the joke to be written to the response writer
 			assert.Equal(http.StatusOK, w.Result().StatusCode)
-			assert.Equal(tt.joke+"\n", string(body))
+			assert.Equal(tt.expJoke+"\n", string(body))
 		})
 	}
 }
+
+func TestServerPrefersJokeStore(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	// the jokes API should never be called when the store has jokes
+	apiCalls := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiCalls++
+		_, err := fmt.Fprint(w, `{"type": "success", "value": { "joke": "from the API"}}`)
+		assert.Nil(err)
+	}))
+	defer api.Close()
+	corpus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, `{"type": "success", "value": [{"id": 1, "joke": "Chuck Norris is from memory."}]}`)
+		assert.Nil(err)
+	}))
+	defer corpus.Close()
+
+	apiUrl, err := url.Parse(api.URL)
+	assert.Nil(err)
+	corpusUrl, err := url.Parse(corpus.URL)
+	assert.Nil(err)
+	store := NewJokeStore(*corpusUrl)
+	assert.Nil(store.Refresh(context.Background()))
+
+	nameChan := make(chan Name, 1)
+	nameChan <- Name{Name: "Bill", Surname: "Murray"}
+	srv := Server{
+		JokeClient: NewJokeClient(*apiUrl),
+		JokeStore:  store,
+		Names:      nameChan,
+	}
+
+	req := httptest.NewRequest("GET", "http://doesnt.matter", nil)
+	w := httptest.NewRecorder()
+	srv.GetCustomJoke(w, req)
+	body, err := ioutil.ReadAll(w.Result().Body)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, w.Result().StatusCode)
+	assert.Equal("Bill Murray is from memory.\n", string(body))
+	assert.Equal(0, apiCalls)
+}
+
+func TestServerReusesCachedNamesWhenNoneAvailable(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, `{"type": "success", "value": { "joke": "Chuck Norris never runs out."}}`)
+		assert.Nil(err)
+	}))
+	defer ts.Close()
+
+	jokeUrl, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	nameChan := make(chan Name, 1)
+	nameChan <- Name{Name: "Bill", Surname: "Murray"}
+	srv := Server{
+		JokeClient: NewJokeClient(*jokeUrl),
+		Names:      nameChan,
+		NameCache:  NewLRUCache(10, 0),
+	}
+
+	// the first request consumes the only fresh name and the second has to reuse it
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "http://doesnt.matter", nil)
+		w := httptest.NewRecorder()
+		srv.GetCustomJoke(w, req)
+		body, err := ioutil.ReadAll(w.Result().Body)
+		assert.Nil(err)
+		assert.Equal(http.StatusOK, w.Result().StatusCode)
+		assert.Equal("Bill Murray never runs out.\n", string(body))
+	}
+}
+
+func TestServerRecordsRequestMetrics(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, `{"type": "success", "value": { "joke": "Chuck Norris is measured."}}`)
+		assert.Nil(err)
+	}))
+	defer ts.Close()
+
+	jokeUrl, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	nameChan := make(chan Name, 1)
+	nameChan <- Name{Name: "Bill", Surname: "Murray"}
+	srv := Server{
+		JokeClient: NewJokeClient(*jokeUrl),
+		Names:      nameChan,
+	}
+
+	req := httptest.NewRequest("GET", "http://doesnt.matter", nil)
+	instrument("/metrics-test", srv.GetCustomJoke)(httptest.NewRecorder(), req)
+
+	metrics := httptest.NewServer(metric.Prometheus{}.Handler("/metrics"))
+	defer metrics.Close()
+	resp, err := metrics.Client().Get(metrics.URL + "/metrics")
+	assert.Nil(err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(err)
+	assert.Contains(string(body), `http_request_total{route="/metrics-test",statuscode="200"} 1`)
+	assert.Contains(string(body), `upstream_request_duration_seconds_count{upstream="jokes"}`)
+}
+
+func TestShuttingDownServer(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	srv := Server{Port: 0, Names: make(chan Name)}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	// give the server time to start listening
+	time.Sleep(time.Millisecond * 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(srv.Shutdown(ctx))
+	select {
+	case err := <-errs:
+		assert.Equal(http.ErrServerClosed, err)
+	case <-time.After(time.Second):
+		assert.Fail("server did not stop after shutdown")
+	}
+}
+
+func TestServerShutdownBeforeListening(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	srv := Server{Port: 0, Names: make(chan Name)}
+	assert.Nil(srv.Shutdown(context.Background()))
+	assert.Equal(http.ErrServerClosed, srv.ListenAndServe())
+}
+
+func TestServerStopsWaitingForNamesWhenClientGoesAway(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	srv := Server{Names: make(chan Name)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "http://doesnt.matter", nil).WithContext(ctx)
+
+	start := time.Now()
+	srv.GetCustomJoke(httptest.NewRecorder(), req)
+	// well short of the time we would otherwise wait for a name
+	assert.True(time.Since(start) < time.Second)
+}
@@ -0,0 +1,240 @@
+package jokesontap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/swtch1/jokesontap/metric"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// nameSnapshotVersion is the version of the snapshot file format written by NameSnapshot.  Increment it whenever
+// the format changes in a way older servers can't read.
+const nameSnapshotVersion = 1
+
+var ErrCorruptNameSnapshot = errors.New("the names snapshot is corrupt or unsupported")
+
+// nameSnapshotFile is the content of a names snapshot file.
+type nameSnapshotFile struct {
+	Version int       `json:"version"`
+	Saved   time.Time `json:"saved"`
+	Names   []Name    `json:"names"`
+}
+
+// PendingNames keeps a copy of the names waiting in a names channel, so they can be saved without taking them from
+// the channel.  Whatever pushes names to the channel or takes them from it must Add or Remove them.  The names are
+// kept as a count of each name, as names may be taken before whatever pushed them has added them.  A nil PendingNames
+// keeps nothing.
+type PendingNames struct {
+	mu     sync.Mutex
+	counts map[Name]int
+}
+
+// NewPendingNames creates an empty PendingNames.
+func NewPendingNames() *PendingNames {
+	return &PendingNames{counts: make(map[Name]int)}
+}
+
+// Add records that name was pushed to the channel.
+func (p *PendingNames) Add(name Name) {
+	p.change(name, 1)
+}
+
+// Remove records that name was taken from the channel.
+func (p *PendingNames) Remove(name Name) {
+	p.change(name, -1)
+}
+
+func (p *PendingNames) change(name Name, by int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := p.counts[name] + by; n != 0 {
+		p.counts[name] = n
+	} else {
+		delete(p.counts, name)
+	}
+}
+
+// Names gets every name waiting in the channel, in no particular order.
+func (p *PendingNames) Names() []Name {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]Name, 0, len(p.counts))
+	for name, n := range p.counts {
+		for i := 0; i < n; i++ {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// NameSnapshot saves the names waiting in a names channel to a file and restores them, so that names which took
+// minutes to gather from the names API are not thrown away when the server restarts.
+type NameSnapshot struct {
+	// Path is the snapshot file.
+	Path string
+	// Names is the channel whose unconsumed names are saved and restored.
+	Names chan Name
+	// Pending is the copy of the names waiting in Names which SaveOften saves.
+	Pending *PendingNames
+	// Interval is how often SaveOften saves a snapshot.
+	Interval time.Duration
+}
+
+// NewNameSnapshot creates a NameSnapshot with default values which saves the names in names to path.
+func NewNameSnapshot(path string, names chan Name) *NameSnapshot {
+	return &NameSnapshot{
+		Path:     path,
+		Names:    names,
+		Pending:  NewPendingNames(),
+		Interval: 5 * time.Minute,
+	}
+}
+
+// Load pushes the names from the snapshot file into the names channel, returning the number of names restored.
+// A missing snapshot file is not an error.  A snapshot file which can't be read is moved aside so that it is not
+// read again and ErrCorruptNameSnapshot is returned.  Names which don't fit in the channel are dropped.
+func (s *NameSnapshot) Load() (int, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to read names snapshot '%s'", s.Path)
+	}
+
+	var snap nameSnapshotFile
+	if err := json.Unmarshal(b, &snap); err != nil || snap.Version != nameSnapshotVersion {
+		return 0, s.setAside(err, snap.Version)
+	}
+
+	var restored int
+	for _, name := range snap.Names {
+		select {
+		case s.Names <- name:
+			s.Pending.Add(name)
+			restored++
+		default:
+			log.WithField("dropped", len(snap.Names)-restored).Warn("names channel is full, dropping remaining snapshot names")
+			metric.ObserveNamesChan(len(s.Names), cap(s.Names))
+			return restored, nil
+		}
+	}
+	metric.ObserveNamesChan(len(s.Names), cap(s.Names))
+	return restored, nil
+}
+
+// setAside renames a corrupt snapshot file so that it can be inspected later but is not loaded again.
+func (s *NameSnapshot) setAside(cause error, version int) error {
+	aside := fmt.Sprintf("%s.corrupt-%d", s.Path, time.Now().Unix())
+	fields := log.Fields{"path": s.Path, "movedTo": aside, "version": version}
+	if err := os.Rename(s.Path, aside); err != nil {
+		log.WithError(err).WithFields(fields).Error("unable to move corrupt names snapshot aside")
+	} else {
+		log.WithFields(fields).Warn("moved corrupt names snapshot aside")
+	}
+	if cause != nil {
+		return errors.Wrap(ErrCorruptNameSnapshot, cause.Error())
+	}
+	return errors.Wrapf(ErrCorruptNameSnapshot, "unsupported snapshot version %d", version)
+}
+
+// Save writes every name currently waiting in the names channel to the snapshot file, returning the number of
+// names saved.  Names are briefly taken from the channel to be saved and are then put back, so Save should only be
+// called once nothing else takes names from or pushes names to the channel, such as when shutting down.  The file is
+// replaced atomically so a crash while saving never leaves a partial snapshot behind.
+func (s *NameSnapshot) Save() (int, error) {
+	names := s.drain()
+	defer s.refill(names)
+	if err := s.write(names); err != nil {
+		return 0, err
+	}
+	return len(names), nil
+}
+
+// SaveOften saves the Pending names every Interval until ctx is done, leaving the names channel alone so that it can
+// be saved while the server is running.  A failed save is logged and retried at the next interval.
+func (s *NameSnapshot) SaveOften(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		names := s.Pending.Names()
+		if err := s.write(names); err != nil {
+			log.WithError(err).WithField("path", s.Path).Error("unable to save names snapshot")
+			continue
+		}
+		log.WithField("names", len(names)).Debug("saved names snapshot")
+	}
+}
+
+// write replaces the snapshot file with one holding names.
+func (s *NameSnapshot) write(names []Name) error {
+	b, err := json.Marshal(nameSnapshotFile{
+		Version: nameSnapshotVersion,
+		Saved:   time.Now().UTC(),
+		Names:   names,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal names snapshot")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "unable to create temporary names snapshot")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "unable to write names snapshot")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "unable to write names snapshot")
+	}
+	if err := os.Rename(tmp.Name(), s.Path); err != nil {
+		return errors.Wrapf(err, "unable to replace names snapshot '%s'", s.Path)
+	}
+	return nil
+}
+
+// drain takes every name currently waiting in the names channel.  Names pushed while draining are left alone.
+func (s *NameSnapshot) drain() []Name {
+	n := len(s.Names)
+	names := make([]Name, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case name := <-s.Names:
+			names = append(names, name)
+		default:
+			return names
+		}
+	}
+	return names
+}
+
+// refill puts names back in the names channel, dropping any which don't fit.
+func (s *NameSnapshot) refill(names []Name) {
+	for _, name := range names {
+		select {
+		case s.Names <- name:
+		default:
+			return
+		}
+	}
+}
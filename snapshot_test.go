@@ -0,0 +1,166 @@
+package jokesontap
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// snapshotDir creates a temporary directory for snapshot files, the returned func removes it.
+func snapshotDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "jokesontap-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestSavingAndLoadingNameSnapshot(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	dir, cleanup := snapshotDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "names.json")
+
+	names := make(chan Name, 10)
+	names <- Name{"John", "Doe"}
+	names <- Name{"Jane", "Roe"}
+	n, err := NewNameSnapshot(path, names).Save()
+	assert.Nil(err)
+	assert.Equal(2, n)
+	// saving must not consume the names
+	assert.Equal(2, len(names))
+
+	restoredNames := make(chan Name, 10)
+	n, err = NewNameSnapshot(path, restoredNames).Load()
+	assert.Nil(err)
+	assert.Equal(2, n)
+	assert.Equal(Name{"John", "Doe"}, <-restoredNames)
+	assert.Equal(Name{"Jane", "Roe"}, <-restoredNames)
+}
+
+func TestPendingNames(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	p := NewPendingNames()
+	p.Add(Name{"Ada", "Lovelace"})
+	p.Add(Name{"Ada", "Lovelace"})
+	p.Add(Name{"Grace", "Hopper"})
+	p.Remove(Name{"Ada", "Lovelace"})
+	// a name may be taken before whatever pushed it has added it
+	p.Remove(Name{"Alan", "Turing"})
+	assert.ElementsMatch([]Name{{"Ada", "Lovelace"}, {"Grace", "Hopper"}}, p.Names())
+	p.Add(Name{"Alan", "Turing"})
+	assert.ElementsMatch([]Name{{"Ada", "Lovelace"}, {"Grace", "Hopper"}}, p.Names())
+
+	var none *PendingNames
+	none.Add(Name{"Ada", "Lovelace"})
+	assert.Empty(none.Names())
+}
+
+func TestSavingNameSnapshotOften(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	dir, cleanup := snapshotDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "names.json")
+
+	names := make(chan Name, 10)
+	snap := NewNameSnapshot(path, names)
+	snap.Interval = 10 * time.Millisecond
+	names <- Name{"Ada", "Lovelace"}
+	snap.Pending.Add(Name{"Ada", "Lovelace"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		snap.SaveOften(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	// the names are saved while they stay in the channel for the server
+	assert.Equal(1, len(names))
+	restoredNames := make(chan Name, 10)
+	n, err := NewNameSnapshot(path, restoredNames).Load()
+	assert.Nil(err)
+	assert.Equal(1, n)
+	assert.Equal(Name{"Ada", "Lovelace"}, <-restoredNames)
+}
+
+func TestLoadingMissingNameSnapshot(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	dir, cleanup := snapshotDir(t)
+	defer cleanup()
+
+	n, err := NewNameSnapshot(filepath.Join(dir, "missing.json"), make(chan Name, 1)).Load()
+	assert.Nil(err)
+	assert.Equal(0, n)
+}
+
+func TestLoadingNameSnapshotIntoSmallChannel(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	dir, cleanup := snapshotDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "names.json")
+
+	contents := `{"version": 1, "names": [{"name": "a", "surname": "a"}, {"name": "b", "surname": "b"}, {"name": "c", "surname": "c"}]}`
+	assert.Nil(ioutil.WriteFile(path, []byte(contents), 0600))
+
+	names := make(chan Name, 2)
+	n, err := NewNameSnapshot(path, names).Load()
+	assert.Nil(err)
+	assert.Equal(2, n)
+	assert.Equal(2, len(names))
+}
+
+func TestLoadingCorruptNameSnapshot(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"truncated", `{"version": 1, "names": [{"name": "Jo`},
+		{"not_json", `names`},
+		{"missing_version", `{"names": [{"name": "John", "surname": "Doe"}]}`},
+		{"future_version", `{"version": 99, "names": [{"name": "John", "surname": "Doe"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := snapshotDir(t)
+			defer cleanup()
+			path := filepath.Join(dir, "names.json")
+			assert.Nil(ioutil.WriteFile(path, []byte(tt.contents), 0600))
+
+			names := make(chan Name, 10)
+			n, err := NewNameSnapshot(path, names).Load()
+			if assert.NotNil(err) {
+				assert.Contains(err.Error(), ErrCorruptNameSnapshot.Error())
+			}
+			assert.Equal(0, n)
+			assert.Equal(0, len(names))
+
+			// the corrupt file is moved aside rather than deleted or loaded again
+			_, err = os.Stat(path)
+			assert.True(os.IsNotExist(err))
+			files, err := ioutil.ReadDir(dir)
+			assert.Nil(err)
+			if assert.Len(files, 1) {
+				assert.True(strings.HasPrefix(files[0].Name(), "names.json.corrupt-"))
+			}
+		})
+	}
+}
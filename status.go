@@ -0,0 +1,127 @@
+package jokesontap
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"github.com/swtch1/jokesontap/breaker"
+	"github.com/swtch1/jokesontap/budget"
+	"net/http"
+	"time"
+)
+
+// BudgetStats describes the state of a budget.
+type BudgetStats struct {
+	// Next is the earliest time the budget will allow another request.
+	Next time.Time `json:"next"`
+	// Recent are the times of the requests in a sliding window budget, oldest first.
+	Recent []time.Time `json:"recent,omitempty"`
+}
+
+// budgetStats describes b, or gets nil when there is no budget.
+func budgetStats(b budget.Budget) *BudgetStats {
+	if b == nil {
+		return nil
+	}
+	stats := &BudgetStats{Next: b.Next()}
+	if w, ok := b.(*budget.SlidingWindow); ok {
+		for _, t := range w.Recent() {
+			// requests which haven't been made yet are left out
+			if !t.IsZero() {
+				stats.Recent = append(stats.Recent, t)
+			}
+		}
+	}
+	return stats
+}
+
+// Status serves a report of what the server is doing as JSON, for operators looking into problems like poor names
+// throughput.
+type Status struct {
+	// Version is the version of the server.
+	Version string
+	// Started is when the server started.
+	Started time.Time
+	// Names is what requests the names which are served.
+	Names *BudgetNameReq
+	// Jokes is the store holding the jokes corpus.
+	Jokes *JokeStore
+	// Breakers are the circuit breakers reported on.
+	Breakers []*breaker.Breaker
+}
+
+// StatusReport is what the server is doing.
+type StatusReport struct {
+	Version       string       `json:"version"`
+	Started       time.Time    `json:"started"`
+	UptimeSeconds float64      `json:"uptimeSeconds"`
+	Names         NamesStatus  `json:"names"`
+	Jokes         *JokesStatus `json:"jokes,omitempty"`
+	// Breakers are the states of the circuit breakers by name.
+	Breakers map[string]string `json:"breakers,omitempty"`
+}
+
+// NamesStatus is what the server is doing to get names.
+type NamesStatus struct {
+	NamesRequestStats
+	// ChanLen is the number of names held ready for requests.
+	ChanLen int `json:"chanLen"`
+	ChanCap int `json:"chanCap"`
+	// Budget is the state of the overall names budget, if there is one.
+	Budget    *BudgetStats        `json:"budget,omitempty"`
+	Providers []NameProviderStats `json:"providers,omitempty"`
+}
+
+// JokesStatus is the state of the jokes corpus.
+type JokesStatus struct {
+	// Size is the number of jokes in the corpus.
+	Size int `json:"size"`
+	// Updated is when the corpus was last successfully refreshed, zero if it never has been.
+	Updated time.Time `json:"updated"`
+	// AgeSeconds is the time since Updated, zero if the corpus has never been refreshed.
+	AgeSeconds float64 `json:"ageSeconds"`
+}
+
+// Report gets what the server is doing now.
+func (s *Status) Report() StatusReport {
+	report := StatusReport{
+		Version:       s.Version,
+		Started:       s.Started,
+		UptimeSeconds: time.Since(s.Started).Seconds(),
+	}
+	if s.Names != nil {
+		report.Names = NamesStatus{
+			NamesRequestStats: s.Names.Stats(),
+			ChanLen:           len(s.Names.NameChan),
+			ChanCap:           cap(s.Names.NameChan),
+			Budget:            budgetStats(s.Names.Budget),
+		}
+		if p, ok := s.Names.NameClient.(*NameProviders); ok {
+			report.Names.Providers = p.Stats()
+		}
+	}
+	if s.Jokes != nil {
+		report.Jokes = &JokesStatus{
+			Size:       s.Jokes.Size(),
+			Updated:    s.Jokes.Updated(),
+			AgeSeconds: s.Jokes.Age().Seconds(),
+		}
+	}
+	if len(s.Breakers) > 0 {
+		report.Breakers = make(map[string]string, len(s.Breakers))
+		for _, b := range s.Breakers {
+			report.Breakers[b.Name] = b.State().String()
+		}
+	}
+	return report
+}
+
+// ServeHTTP serves the status report.
+func (s *Status) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJson)
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.Report()); err != nil {
+		log.WithContext(req.Context()).WithError(err).Error("unable to write status response")
+	}
+}
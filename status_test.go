@@ -0,0 +1,70 @@
+package jokesontap
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/swtch1/jokesontap/breaker"
+	"github.com/swtch1/jokesontap/budget"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBudgetNameReqRecordsStats(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	requester := &stubNameRequester{name: "Ada"}
+	b := BudgetNameReq{NameClient: requester, NameChan: make(chan Name, 10)}
+	b.pushNamesFromAPI(context.Background())
+	requester.err = withRetryAfter(ErrNamesApiTooManyRequests, &http.Response{Header: http.Header{"Retry-After": {"0"}}})
+	b.pushNamesFromAPI(context.Background())
+
+	stats := b.Stats()
+	assert.Equal(2, stats.Requests)
+	assert.Equal(1, stats.Names)
+	assert.Equal(1, stats.TooManyRequests)
+	assert.Contains(stats.LastError, ErrNamesApiTooManyRequests.Error())
+	assert.WithinDuration(time.Now(), stats.LastErrorAt, time.Second)
+}
+
+func TestStatusReport(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	window := budget.NewSlidingWindow(3, time.Minute)
+	providers := NewNameProviders(&NameProvider{Name: "primary", Requester: &stubNameRequester{name: "Ada"}, Budget: window})
+	names := &BudgetNameReq{NameClient: providers, NameChan: make(chan Name, 10)}
+	names.pushNamesFromAPI(context.Background())
+	jokes := breaker.New("jokes", 1, time.Minute)
+
+	updated := time.Now().Add(-time.Minute)
+	store := &JokeStore{jokes: []JokeValue{{ID: 1}, {ID: 2}}, updated: updated}
+
+	status := &Status{Version: "1.2.3", Started: time.Now().Add(-time.Hour), Names: names, Jokes: store, Breakers: []*breaker.Breaker{jokes}}
+	w := httptest.NewRecorder()
+	status.ServeHTTP(w, httptest.NewRequest("GET", "/debug/status", nil))
+	assert.Equal(contentTypeJson, w.Header().Get("Content-Type"))
+
+	var report StatusReport
+	assert.Nil(json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal("1.2.3", report.Version)
+	assert.True(report.UptimeSeconds >= time.Hour.Seconds())
+	assert.Equal(1, report.Names.ChanLen)
+	assert.Equal(10, report.Names.ChanCap)
+	assert.Equal(1, report.Names.Names)
+	assert.Nil(report.Names.Budget)
+	assert.Equal(map[string]string{"jokes": "closed"}, report.Breakers)
+	if assert.NotNil(report.Jokes) {
+		assert.Equal(2, report.Jokes.Size)
+		assert.True(updated.Equal(report.Jokes.Updated))
+		assert.True(report.Jokes.AgeSeconds >= time.Minute.Seconds())
+	}
+
+	if assert.Len(report.Names.Providers, 1) && assert.NotNil(report.Names.Providers[0].Budget) {
+		assert.Len(report.Names.Providers[0].Budget.Recent, 1)
+		assert.True(report.Names.Providers[0].Budget.Next.IsZero(), "the window isn't full yet")
+	}
+}
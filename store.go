@@ -0,0 +1,268 @@
+package jokesontap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/swtch1/jokesontap/metric"
+	"html"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	ErrJokeStoreEmpty          = errors.New("the joke store has no jokes to serve")
+	ErrUnsuccessfulCorpusQuery = errors.New("general error getting the jokes corpus")
+)
+
+// jokeList maps to the Internet Chuck Norris database API response when requesting all jokes at once.
+type jokeList struct {
+	Type  string      `json:"type"`
+	Value []JokeValue `json:"value"`
+}
+
+// JokeStore holds the entire jokes database in memory so that jokes can be served without calling out to the
+// external service on every request.  The corpus is small enough that it can simply be downloaded in full and
+// replaced on a regular schedule.  Jokes are stored as templates with the default name, which is substituted
+// when a joke is served.
+type JokeStore struct {
+	// ApiUrl is the URL of the jokes API which returns every joke in a single response.
+	ApiUrl url.URL
+	// HttpClient is a http client which can be reused across multiple requests.
+	HttpClient *http.Client
+	// Category limits the corpus to jokes in the given category.  All jokes are downloaded when empty, which
+	// allows every category to be served.
+	Category string
+	// RefreshInterval is how often the full corpus is downloaded and swapped in.
+	RefreshInterval time.Duration
+
+	// mu guards jokes and updated, which are always replaced together.
+	mu      sync.RWMutex
+	jokes   []JokeValue
+	updated time.Time
+}
+
+// NewJokeStore creates an empty JokeStore with default values where corpusUrl is the API URL which returns
+// all jokes.  The store must be refreshed before any jokes can be served.
+func NewJokeStore(corpusUrl url.URL) *JokeStore {
+	return &JokeStore{
+		ApiUrl:          corpusUrl,
+		RefreshInterval: 24 * time.Hour,
+		HttpClient: &http.Client{
+			// the full corpus is considerably larger than a single joke
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConns:    1,
+				IdleConnTimeout: 30 * time.Second,
+			},
+		},
+	}
+}
+
+// RefreshOften downloads the full jokes corpus every RefreshInterval until ctx is done.  A failed refresh
+// is logged and the last good copy of the corpus continues to be served.
+func (s *JokeStore) RefreshOften(ctx context.Context) {
+	ticker := time.NewTicker(s.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.Refresh(ctx); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"size": s.Size(),
+				"age":  s.Age().String(),
+			}).Error("unable to refresh jokes corpus, continuing with last good copy")
+		}
+	}
+}
+
+// Refresh downloads the full jokes corpus and swaps it in for the current one.  The current corpus is kept
+// if any part of the download fails, including when ctx is done before the download completes.
+func (s *JokeStore) Refresh(ctx context.Context) error {
+	jokes, err := s.download(ctx)
+	if err != nil {
+		return err
+	}
+
+	updated := time.Now()
+	s.mu.Lock()
+	s.jokes = jokes
+	s.updated = updated
+	s.mu.Unlock()
+	metric.ObserveJokesCorpus(len(jokes), updated)
+
+	log.WithField("size", len(jokes)).Info("jokes corpus refreshed")
+	return nil
+}
+
+func (s *JokeStore) download(ctx context.Context) (jokes []JokeValue, err error) {
+	start := time.Now()
+	defer func() { metric.ObserveUpstream(metric.UpstreamJokes, start, err) }()
+
+	apiUrl := s.corpusUrl()
+	req, err := http.NewRequestWithContext(ctx, "GET", apiUrl, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create new http request with URL '%s'", apiUrl)
+	}
+	req.Header.Set("Accept", "application/json")
+	log.Trace("getting jokes corpus")
+	resp, err := s.HttpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get jokes corpus from '%s'", apiUrl)
+	}
+	defer resp.Body.Close()
+
+	var list jokeList
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read jokes API response body")
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal jokes API corpus response body")
+	}
+	if list.Type != "success" || len(list.Value) == 0 {
+		return nil, ErrUnsuccessfulCorpusQuery
+	}
+	// only keep the jokes we are able to substitute a custom name into
+	jokes = make([]JokeValue, 0, len(list.Value))
+	for _, joke := range list.Value {
+		joke.Joke = html.UnescapeString(joke.Joke)
+		if !hasPlaceholder(joke.Joke) {
+			log.WithField("id", joke.ID).Debug("dropping joke without a name to substitute")
+			continue
+		}
+		jokes = append(jokes, joke)
+	}
+	if len(jokes) == 0 {
+		return nil, ErrUnsuccessfulCorpusQuery
+	}
+	return jokes, nil
+}
+
+// corpusUrl is the API URL limited to the store's category, if set.
+func (s *JokeStore) corpusUrl() string {
+	u := s.ApiUrl
+	if s.Category != "" {
+		params := u.Query()
+		params.Set("limitTo", fmt.Sprintf("[%s]", s.Category))
+		u.RawQuery = params.Encode()
+	}
+	return u.String()
+}
+
+// Size is the number of jokes currently held in the store.
+func (s *JokeStore) Size() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.jokes)
+}
+
+// Age is the time since the corpus was last successfully refreshed, or zero if it never has been.
+func (s *JokeStore) Age() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.updated.IsZero() {
+		return 0
+	}
+	return time.Since(s.updated)
+}
+
+// Updated is when the corpus was last successfully refreshed, or the zero time if it never has been.
+func (s *JokeStore) Updated() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.updated
+}
+
+// JokesWithCustomNames gets a random joke passing filter from the store for each of names, substituting each name
+// into its own joke.  Jokes are not repeated unless there are fewer jokes passing filter than names.  If no jokes pass
+// the filter an ErrNoJokesInCategory error is returned.
+func (s *JokeStore) JokesWithCustomNames(names []Name, filter CategoryFilter) ([]JokeValue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.jokes) == 0 {
+		return nil, ErrJokeStoreEmpty
+	}
+	candidates := s.filtered(filter)
+	if len(candidates) == 0 {
+		return nil, ErrNoJokesInCategory
+	}
+
+	order := rand.Perm(len(candidates))
+	jokes := make([]JokeValue, 0, len(names))
+	for i, name := range names {
+		joke := candidates[order[i%len(order)]]
+		text, err := substituteName(joke.Joke, name.Name, name.Surname)
+		if err != nil {
+			return nil, err
+		}
+		joke.Joke = text
+		jokes = append(jokes, joke)
+	}
+	return jokes, nil
+}
+
+// filtered gets the jokes passing filter.  The caller must hold the read lock.
+func (s *JokeStore) filtered(filter CategoryFilter) []JokeValue {
+	if filter.empty() {
+		return s.jokes
+	}
+	jokes := make([]JokeValue, 0, len(s.jokes))
+	for _, joke := range s.jokes {
+		if filter.allows(joke.Categories) {
+			jokes = append(jokes, joke)
+		}
+	}
+	return jokes
+}
+
+// JokeByID gets the joke with the given ID from the store, with the default name.  False is returned if the store
+// doesn't hold the joke.
+func (s *JokeStore) JokeByID(id int) (JokeValue, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, joke := range s.jokes {
+		if joke.ID == id {
+			return joke, true
+		}
+	}
+	return JokeValue{}, false
+}
+
+// Categories gets the distinct categories of the jokes in the store, sorted.
+func (s *JokeStore) Categories() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return uniqueCategories(s.jokes)
+}
+
+// JokeWithCustomName gets a random joke passing filter from the store using the first and last name passed in.  If
+// no jokes pass the filter an ErrNoJokesInCategory error is returned.
+func (s *JokeStore) JokeWithCustomName(fName, lName string, filter CategoryFilter) (JokeValue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.jokes) == 0 {
+		return JokeValue{}, ErrJokeStoreEmpty
+	}
+
+	jokes := s.filtered(filter)
+	if len(jokes) == 0 {
+		return JokeValue{}, ErrNoJokesInCategory
+	}
+	joke := jokes[rand.Intn(len(jokes))]
+	text, err := substituteName(joke.Joke, fName, lName)
+	if err != nil {
+		return JokeValue{}, err
+	}
+	joke.Joke = text
+	return joke, nil
+}
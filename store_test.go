@@ -0,0 +1,140 @@
+package jokesontap
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRefreshingJokeStore(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		name    string
+		resp    string
+		expSize int
+	}{
+		{"single_joke", `{"type": "success", "value": [{"id": 1, "joke": "Chuck Norris can divide by zero."}]}`, 1},
+		{
+			"multiple_jokes",
+			`{"type": "success", "value": [{"id": 1, "joke": "Chuck Norris a"}, {"id": 2, "joke": "Chuck b"}, {"id": 3, "joke": "Norris c"}]}`,
+			3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, err := fmt.Fprint(w, tt.resp)
+				assert.Nil(err)
+			}))
+			defer ts.Close()
+
+			u, err := url.Parse(ts.URL)
+			assert.Nil(err)
+			js := NewJokeStore(*u)
+			assert.Equal(0, js.Size())
+			assert.Equal(time.Duration(0), js.Age())
+
+			assert.Nil(js.Refresh(context.Background()))
+			assert.Equal(tt.expSize, js.Size())
+			assert.True(js.Age() > 0)
+		})
+	}
+}
+
+func TestFailedJokeStoreRefreshKeepsLastGoodCopy(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		name    string
+		badResp string
+	}{
+		{"invalid_json", `{"invalid"`},
+		{"unsuccessful", `{"type": "failure", "value": []}`},
+		{"empty", `{"type": "success", "value": []}`},
+		{"no_placeholders", `{"type": "success", "value": [{"id": 2, "joke": "nobody to replace"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fail := false
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				resp := `{"type": "success", "value": [{"id": 1, "joke": "Chuck Norris counted to infinity."}]}`
+				if fail {
+					resp = tt.badResp
+				}
+				_, err := fmt.Fprint(w, resp)
+				assert.Nil(err)
+			}))
+			defer ts.Close()
+
+			u, err := url.Parse(ts.URL)
+			assert.Nil(err)
+			js := NewJokeStore(*u)
+			assert.Nil(js.Refresh(context.Background()))
+
+			fail = true
+			assert.NotNil(js.Refresh(context.Background()))
+			assert.Equal(1, js.Size())
+			joke, err := js.JokeWithCustomName("John", "Smith", CategoryFilter{})
+			assert.Nil(err)
+			assert.Equal("John Smith counted to infinity.", joke.Joke)
+		})
+	}
+}
+
+func TestJokeStoreRequestsConfiguredCategory(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var query url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_, err := fmt.Fprint(w, `{"type": "success", "value": [{"id": 1, "joke": "Chuck Norris x"}]}`)
+		assert.Nil(err)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	js := NewJokeStore(*u)
+	js.Category = "explicit"
+	assert.Nil(js.Refresh(context.Background()))
+	assert.Equal("[explicit]", query.Get("limitTo"))
+}
+
+func TestEmptyJokeStoreErrors(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	js := NewJokeStore(url.URL{})
+	_, err := js.JokeWithCustomName("John", "Smith", CategoryFilter{})
+	assert.Equal(ErrJokeStoreEmpty, err)
+}
+
+func TestJokeStoreDropsJokesWithoutPlaceholder(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, `{"type": "success", "value": [{"id": 1, "joke": "Chuck Norris x"}, {"id": 2, "joke": "y"}]}`)
+		assert.Nil(err)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	assert.Nil(err)
+	js := NewJokeStore(*u)
+	assert.Nil(js.Refresh(context.Background()))
+	assert.Equal(1, js.Size())
+}
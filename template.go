@@ -0,0 +1,93 @@
+package jokesontap
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+)
+
+// ErrNameNotSubstituted occurs when a joke template does not contain the default name placeholder.
+var ErrNameNotSubstituted = errors.New("joke template does not contain a name to substitute")
+
+// placeholder matches the default name used in joke templates.  The full name is preferred over either half so
+// that "Chuck Norris" is replaced as a whole, and a trailing apostrophe or "'s" is captured as a possessive.  A
+// trailing apostrophe may be a closing quote instead, which possessiveSuffix decides.
+var placeholder = regexp.MustCompile(`\b(Chuck Norris|Chuck|Norris)\b('s\b|’s\b|'|’)?`)
+
+// hasPlaceholder returns true when the joke template contains a name which can be substituted.
+func hasPlaceholder(template string) bool {
+	return placeholder.MatchString(template)
+}
+
+// substituteName replaces the default "Chuck Norris" name in a joke template with the first and last name
+// passed in.  The first and last names stand in for "Chuck" and "Norris" when used individually, and possessives
+// are rewritten to suit the new name.  An ErrNameNotSubstituted error is returned if no replacement was made.
+func substituteName(template, fName, lName string) (string, error) {
+	matches := placeholder.FindAllStringSubmatchIndex(template, -1)
+	if len(matches) == 0 {
+		return "", ErrNameNotSubstituted
+	}
+
+	fullName := strings.TrimSpace(fName + " " + lName)
+	var joke strings.Builder
+	last := 0
+	for _, m := range matches {
+		placeholderName := template[m[2]:m[3]]
+		var suffix string
+		if m[4] >= 0 {
+			suffix = template[m[4]:m[5]]
+		}
+
+		var name string
+		switch placeholderName {
+		case "Chuck Norris":
+			name = fullName
+		case "Chuck":
+			name = fName
+		case "Norris":
+			name = lName
+		}
+		if name == "" {
+			// we have nothing to stand in for this half of the name, so fall back to what we do have
+			name = fullName
+		}
+
+		joke.WriteString(template[last:m[0]])
+		if possessiveSuffix(template[:m[0]], placeholderName, suffix) {
+			joke.WriteString(possessive(name, suffix))
+		} else {
+			joke.WriteString(name + suffix)
+		}
+		last = m[1]
+	}
+	joke.WriteString(template[last:])
+	return joke.String(), nil
+}
+
+// possessiveSuffix returns true if suffix, following placeholderName in a template after before, makes the name
+// possessive.  A bare apostrophe is only possessive after "Norris", which already ends in "s", and not when the name
+// was opened with a quote, as in "He said 'Chuck Norris' loudly."
+func possessiveSuffix(before, placeholderName, suffix string) bool {
+	switch suffix {
+	case "":
+		return false
+	case "'", "’":
+		if !strings.HasSuffix(placeholderName, "Norris") {
+			return false
+		}
+		return !strings.HasSuffix(before, "'") && !strings.HasSuffix(before, "‘")
+	}
+	return true
+}
+
+// possessive makes name possessive with suffix, keeping the style of apostrophe used.
+func possessive(name, suffix string) string {
+	apostrophe := "'"
+	if strings.HasPrefix(suffix, "’") {
+		apostrophe = "’"
+	}
+	if strings.HasSuffix(name, "s") || strings.HasSuffix(name, "S") {
+		return name + apostrophe
+	}
+	return name + apostrophe + "s"
+}
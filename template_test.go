@@ -0,0 +1,53 @@
+package jokesontap
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSubstitutingNames(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	tests := []struct {
+		name     string
+		template string
+		fName    string
+		lName    string
+		expJoke  string
+	}{
+		{"full_name", "Chuck Norris can divide by zero.", "Bill", "Murray", "Bill Murray can divide by zero."},
+		{"first_name", "Nobody beats Chuck at chess.", "Bill", "Murray", "Nobody beats Bill at chess."},
+		{"last_name", "Ask Norris for directions.", "Bill", "Murray", "Ask Murray for directions."},
+		{"multiple", "Chuck Norris met Chuck and Norris.", "Ada", "Lovelace", "Ada Lovelace met Ada and Lovelace."},
+		{"possessive", "Chuck Norris's beard is a compiler.", "Bill", "Murray", "Bill Murray's beard is a compiler."},
+		{"possessive_apostrophe_only", "Chuck Norris' beard.", "Bill", "Murray", "Bill Murray's beard."},
+		{"possessive_ending_in_s", "Chuck Norris's beard.", "Tom", "Jones", "Tom Jones' beard."},
+		{"possessive_first_name", "Chuck's beard.", "Bill", "Murray", "Bill's beard."},
+		{"possessive_curly", "Chuck Norris’s beard.", "Bill", "Murray", "Bill Murray’s beard."},
+		{"no_last_name", "Norris wins.", "Cher", "", "Cher wins."},
+		{"partial_word", "Chuckles and Chuck Norris.", "Bill", "Murray", "Chuckles and Bill Murray."},
+		{"quoted_full_name", "He said 'Chuck Norris' loudly.", "Ada", "Lovelace", "He said 'Ada Lovelace' loudly."},
+		{"quoted_full_name_curly", "He said ‘Chuck Norris’ loudly.", "Ada", "Lovelace", "He said ‘Ada Lovelace’ loudly."},
+		{"quoted_first_name", "Call me 'Chuck' please.", "Ada", "Lovelace", "Call me 'Ada' please."},
+		{"quoted_last_name", "They call him 'Norris'.", "Ada", "Lovelace", "They call him 'Lovelace'."},
+		{"quoted_possessive", "'Chuck Norris's beard' is a song.", "Ada", "Lovelace", "'Ada Lovelace's beard' is a song."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			joke, err := substituteName(tt.template, tt.fName, tt.lName)
+			assert.Nil(err)
+			assert.Equal(tt.expJoke, joke)
+		})
+	}
+}
+
+func TestSubstitutingNamesWithoutPlaceholderErrors(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	_, err := substituteName("There is nobody in this joke.", "Bill", "Murray")
+	assert.Equal(ErrNameNotSubstituted, err)
+	assert.False(hasPlaceholder("There is nobody in this joke."))
+}